@@ -0,0 +1,353 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/jinzhu/gorm"
+)
+
+// account settings
+const (
+	AccountDeletionGracePeriod = 7 * 24 * time.Hour // the account is deleted this long after the confirmation, unless canceled
+	DataExportCooldown         = 24 * time.Hour     // time between two data exports of a user
+)
+
+// the deletion job deletes the account once the grace period is over
+func init() {
+	registerJobHandler("account_deletion", runAccountDeletion)
+}
+
+// accountJobPayload is the payload of the account deletion job
+type accountJobPayload struct {
+	TelegramID int64
+}
+
+// accountDeletionKeyboard confirms or cancels the deletion
+func accountDeletionKeyboard(user *User) tgbotapi.InlineKeyboardMarkup {
+	if user.DeletionScheduledAt != nil {
+		return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("↩️ Keep my account", "account:cancel"),
+		))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🗑️ Yes, delete my account", "account:delete"),
+		tgbotapi.NewInlineKeyboardButtonData("❌ No", "account:keep"),
+	))
+}
+
+// handleDeleteAccountCommand asks to confirm the deletion, or shows the scheduled deletion
+func handleDeleteAccountCommand(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	if user.DeletionScheduledAt != nil {
+		sendMessage(bot, chatID, fmt.Sprintf("🗑️ Your account will be deleted at %s.",
+			user.DeletionScheduledAt.In(userLocation(user)).Format("2006-01-02 15:04")), accountDeletionKeyboard(user))
+		return
+	}
+
+	sendMessage(bot, chatID, fmt.Sprintf("🗑️ Do you want to delete your account?\n\n"+
+		"Your profile is hidden right away and deleted after %d days with your photo, connections, messages, ratings, words and all other data. "+
+		"You can change your mind until then with /deleteaccount.\nUse /exportdata first to keep a copy of your data.",
+		int(AccountDeletionGracePeriod.Hours()/24)), accountDeletionKeyboard(user))
+}
+
+// handleAccountCallback confirms or cancels the deletion of the account
+func handleAccountCallback(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	callback := update.CallbackQuery
+	chatID := callback.Message.Chat.ID
+
+	var user User
+	if err := db.Where("telegram_id = ?", chatID).First(&user).Error; err != nil {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+
+	key := fmt.Sprintf("account:%d", user.TelegramID)
+	switch strings.TrimPrefix(callback.Data, "account:") {
+	case "delete":
+		if user.DeletionScheduledAt != nil {
+			bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+			return
+		}
+		deleteAt := time.Now().Add(AccountDeletionGracePeriod)
+		if err := scheduleJob("account_deletion", key, deleteAt, accountJobPayload{TelegramID: user.TelegramID}); err != nil {
+			log.Println("Error scheduling account deletion:", err)
+			bot.AnswerCallbackQuery(tgbotapi.NewCallbackWithAlert(callback.ID, "Failed to delete your account. Please try again."))
+			return
+		}
+		db.Model(&user).Update("deletion_scheduled_at", &deleteAt)
+
+		// the user leaves the live features right away
+		leavePracticeNowQueue(user.TelegramID)
+		if session := getPracticeNowSession(user.TelegramID); session != nil {
+			endPracticeNowSession(bot, session, "Your partner left the chat.")
+		}
+		db.Where("user_id = ?", user.TelegramID).Delete(&RoomWaiting{})
+
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		removeInlineKeyboard(bot, callback.Message)
+		sendMessage(bot, chatID, fmt.Sprintf("🗑️ Your profile is hidden and your account will be deleted at %s. Changed your mind? Use /deleteaccount.",
+			deleteAt.In(userLocation(&user)).Format("2006-01-02 15:04")), mainKeyboard)
+	case "cancel":
+		cancelJobs("account_deletion", key)
+		db.Model(&user).Update("deletion_scheduled_at", gorm.Expr("NULL"))
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		removeInlineKeyboard(bot, callback.Message)
+		sendMessage(bot, chatID, "🎉 Welcome back! Your account will not be deleted.", mainKeyboard)
+	default:
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		removeInlineKeyboard(bot, callback.Message)
+	}
+}
+
+// runAccountDeletion deletes the account when its deletion is still scheduled
+func runAccountDeletion(bot *tgbotapi.BotAPI, job *ScheduledJob) error {
+	var payload accountJobPayload
+	if err := job.decodePayload(&payload); err != nil {
+		return err
+	}
+
+	var user User
+	if err := db.Where("telegram_id = ? AND deletion_scheduled_at IS NOT NULL", payload.TelegramID).First(&user).Error; err != nil {
+		// deletion was canceled
+		return nil
+	}
+	if err := deleteAccount(bot, &user); err != nil {
+		return err
+	}
+	sendMessage(bot, payload.TelegramID, "🗑️ Your account and all your data have been deleted. Send /start to register again.", tgbotapi.NewRemoveKeyboard(true))
+	return nil
+}
+
+// userDataQueries returns the queries selecting every row stored about the user, by table, each with its model set.
+// New tables with user data must be added here, they are used by the deletion. Rows shared with other users
+// are selected from both sides, tables with such rows need an override in userExportQueries.
+func userDataQueries(tx *gorm.DB, telegramID int64) map[string]*gorm.DB {
+	id := telegramID
+	pairKeys := fmt.Sprintf("%d:%%", id)
+	return map[string]*gorm.DB{
+		"follow_requests":     tx.Unscoped().Model(&FollowRequest{}).Where("requester_id = ? OR target_id = ?", id, id),
+		"watch_lists":         tx.Model(&WatchList{}).Where("user_id = ? OR watch_id = ?", id, id),
+		"browse_sessions":     tx.Model(&BrowseSession{}).Where("user_id = ?", id),
+		"hidden_partners":     tx.Model(&HiddenPartner{}).Where("user_id = ? OR hidden_id = ?", id, id),
+		"partner_reports":     tx.Model(&PartnerReport{}).Where("reporter_id = ? OR reported_id = ?", id, id),
+		"quota_usages":        tx.Model(&QuotaUsage{}).Where("user_id = ?", id),
+		"quota_bonuses":       tx.Model(&QuotaBonus{}).Where("user_id = ?", id),
+		"subscriptions":       tx.Model(&Subscription{}).Where("user_id = ?", id),
+		"partner_likes":       tx.Model(&PartnerLike{}).Where("user_id = ? OR liked_id = ?", id, id),
+		"practice_sessions":   tx.Unscoped().Model(&PracticeSession{}).Where("proposer_id = ? OR partner_id = ?", id, id),
+		"topic_usages":        tx.Model(&TopicUsage{}).Where("pair_key LIKE ? OR pair_key LIKE ?", pairKeys, fmt.Sprintf("%%:%d", id)),
+		"placement_attempts":  tx.Model(&PlacementAttempt{}).Where("user_id = ?", id),
+		"user_languages":      tx.Model(&UserLanguage{}).Where("user_id = ?", id),
+		"room_waitings":       tx.Model(&RoomWaiting{}).Where("user_id = ?", id),
+		"partner_ratings":     tx.Model(&PartnerRating{}).Where("rater_id = ? OR ratee_id = ?", id, id),
+		"activity_events":     tx.Model(&ActivityEvent{}).Where("user_id = ?", id),
+		"user_badges":         tx.Model(&UserBadge{}).Where("user_id = ?", id),
+		"leaderboard_entries": tx.Model(&LeaderboardEntry{}).Where("user_id = ?", id),
+		"vocab_words":         tx.Model(&VocabWord{}).Where("user_id = ?", id),
+		"referrals":           tx.Model(&Referral{}).Where("referrer_id = ? OR referred_id = ?", id, id),
+	}
+}
+
+// userExportQueries returns the queries of userDataQueries limited to the rows the user owns. Rows about the user
+// written by other users, e.g. reports, ratings or likes of the user, belong to their authors and are not exported.
+func userExportQueries(tx *gorm.DB, telegramID int64) map[string]*gorm.DB {
+	id := telegramID
+	queries := userDataQueries(tx, id)
+	queries["follow_requests"] = tx.Unscoped().Model(&FollowRequest{}).Where("requester_id = ?", id)
+	queries["watch_lists"] = tx.Model(&WatchList{}).Where("user_id = ?", id)
+	queries["hidden_partners"] = tx.Model(&HiddenPartner{}).Where("user_id = ?", id)
+	queries["partner_reports"] = tx.Model(&PartnerReport{}).Where("reporter_id = ?", id)
+	queries["partner_likes"] = tx.Model(&PartnerLike{}).Where("user_id = ?", id)
+	queries["partner_ratings"] = tx.Model(&PartnerRating{}).Where("rater_id = ?", id)
+	queries["referrals"] = tx.Model(&Referral{}).Where("referrer_id = ?", id)
+	return queries
+}
+
+// deleteAccount removes the user and everything stored about the user from the database, the media storage and Redis,
+// cancels the jobs of the user and tells the partners of upcoming practice sessions
+func deleteAccount(bot *tgbotapi.BotAPI, user *User) error {
+	id := user.TelegramID
+
+	// partners rated by the user get their reputation recomputed without the rating
+	var rateeIDs []int64
+	db.Model(&PartnerRating{}).Where("rater_id = ?", id).Pluck("ratee_id", &rateeIDs)
+
+	// upcoming practice sessions are canceled with the account
+	var practiceSessions []PracticeSession
+	db.Where("(proposer_id = ? OR partner_id = ?) AND status IN (?)", id, id,
+		[]string{PracticeStatusProposed, PracticeStatusScheduled}).Find(&practiceSessions)
+
+	var media Media
+	hasMedia := user.MediaID != 0 && db.First(&media, user.MediaID).Error == nil
+
+	tx := db.Begin()
+	for table, query := range userDataQueries(tx, id) {
+		if err := query.Delete(query.Value).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("deleting %s of user %d: %v", table, id, err)
+		}
+	}
+	// group practice history keeps the group, without the user
+	var groups []RoomGroup
+	tx.Where("',' || member_ids || ',' LIKE ?", fmt.Sprintf("%%,%d,%%", id)).Find(&groups)
+	var closedGroupIDs []uint
+	for _, group := range groups {
+		var memberIDs []int64
+		for _, memberID := range splitIDs(group.MemberIDs) {
+			if memberID != id {
+				memberIDs = append(memberIDs, memberID)
+			}
+		}
+		tx.Model(&group).Update("member_ids", joinIDs(memberIDs))
+
+		// a running group left without members is closed now, the others close on their close job
+		if group.ArchivedAt == nil && len(memberIDs) == 0 {
+			now := time.Now()
+			tx.Model(&group).Update("archived_at", &now)
+			tx.Model(&PracticeRoom{}).Where("id = ? AND group_id = ?", group.RoomID, group.ID).Update("group_id", 0)
+			closedGroupIDs = append(closedGroupIDs, group.ID)
+		}
+	}
+	if hasMedia {
+		if err := tx.Delete(&media).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("deleting media of user %d: %v", id, err)
+		}
+	}
+	if err := tx.Unscoped().Where("telegram_id = ?", id).Delete(&User{}).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("deleting user %d: %v", id, err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	if hasMedia {
+		if err := os.Remove(media.Filename); err != nil && !os.IsNotExist(err) {
+			log.Println("Error removing file from storage:", err)
+		}
+	}
+	for _, rateeID := range rateeIDs {
+		updateReputation(rateeID)
+	}
+	cancelAccountJobs(id, practiceSessions, closedGroupIDs)
+	for _, session := range practiceSessions {
+		partnerID := session.otherUserID(id)
+		var partner User
+		if db.Where("telegram_id = ?", partnerID).First(&partner).Error != nil {
+			continue
+		}
+		text := fmt.Sprintf("📅 %s deleted their account, the proposed practice session is canceled.", user.Name)
+		if session.StartsAt != nil {
+			text = fmt.Sprintf("📅 %s deleted their account, your practice session at %s is canceled.",
+				user.Name, session.StartsAt.In(userLocation(&partner)).Format(PracticeSlotLayout))
+		}
+		sendMessage(bot, partnerID, text, nil)
+	}
+	for _, group := range groups {
+		if group.ArchivedAt == nil {
+			var room PracticeRoom
+			if db.First(&room, group.RoomID).Error == nil {
+				removeRoomMember(bot, room.ChatID, id)
+			}
+		}
+	}
+
+	leavePracticeNowQueue(id)
+	redisClient.Del(context.Background(), practiceNowUserKey+strconv.FormatInt(id, 10))
+	return nil
+}
+
+// cancelAccountJobs removes the pending jobs of the user: rating prompts of the user or about the user,
+// the reminders and attendance checks of the practice sessions and the close jobs of the closed room groups
+func cancelAccountJobs(telegramID int64, practiceSessions []PracticeSession, closedGroupIDs []uint) {
+	pending := db.Where("done_at IS NULL AND failed_at IS NULL")
+
+	pending.Where("name = ? AND (key LIKE ? OR key LIKE ?)", "rating_prompt",
+		fmt.Sprintf("rating:%d:%%", telegramID), fmt.Sprintf("rating:%%:%d", telegramID)).Delete(&ScheduledJob{})
+
+	var practiceKeys []string
+	for _, session := range practiceSessions {
+		practiceKeys = append(practiceKeys, fmt.Sprintf("practice:%d", session.ID))
+	}
+	if len(practiceKeys) > 0 {
+		pending.Where("name IN (?) AND key IN (?)", []string{"practice_reminder", "practice_attendance"}, practiceKeys).Delete(&ScheduledJob{})
+	}
+
+	for _, groupID := range closedGroupIDs {
+		cancelJobs("practice_room_close", fmt.Sprintf("room:%d", groupID))
+	}
+}
+
+// handleExportDataCommand sends a ZIP archive of everything stored about the user
+func handleExportDataCommand(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	if since := time.Since(user.LastDataExportAt); since < DataExportCooldown {
+		sendMessage(bot, chatID, fmt.Sprintf("You can export your data again in %s.", formatDuration(DataExportCooldown-since)), mainKeyboard)
+		return
+	}
+
+	archive, err := userDataArchive(user)
+	if err != nil {
+		log.Println("Error exporting user data:", err)
+		sendErrorMessage(bot, chatID, "Failed to export your data. Please try again.")
+		return
+	}
+
+	document := tgbotapi.NewDocumentUpload(chatID, tgbotapi.FileBytes{Name: "partner-go-data.zip", Bytes: archive})
+	document.Caption = "📦 Everything we store about you"
+	if _, err := bot.Send(document); err != nil {
+		log.Println("Error sending data export:", err)
+		return
+	}
+	db.Model(user).Update("last_data_export_at", time.Now())
+}
+
+// userDataArchive builds a ZIP with data.json holding the user row and the rows the user owns in every table, and the profile photo
+func userDataArchive(user *User) ([]byte, error) {
+	data := map[string]interface{}{"user": user}
+	for table, query := range userExportQueries(db, user.TelegramID) {
+		// rows are loaded into a slice of the model of the query
+		rows := reflect.New(reflect.SliceOf(reflect.TypeOf(query.Value).Elem())).Interface()
+		if err := query.Find(rows).Error; err != nil {
+			return nil, fmt.Errorf("exporting %s: %v", table, err)
+		}
+		data[table] = rows
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	file, err := archive.Create("data.json")
+	if err != nil {
+		return nil, err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(data); err != nil {
+		return nil, err
+	}
+
+	if media := getUserMedia(user); media != nil {
+		photo, err := os.ReadFile(media.Filename)
+		if err != nil {
+			log.Println("Error reading profile photo for export:", err)
+		} else if file, err := archive.Create("profile_photo" + filepath.Ext(media.Filename)); err == nil {
+			file.Write(photo)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// isAdmin checks if the telegram ID is listed in the ADMIN_TELEGRAM_IDS env variable (comma separated)
+func isAdmin(telegramID int64) bool {
+	for _, part := range strings.Split(os.Getenv("ADMIN_TELEGRAM_IDS"), ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err == nil && id == telegramID {
+			return true
+		}
+	}
+	return false
+}
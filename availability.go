@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// availability grid settings, the week of a user is split into blocks of their local time
+const (
+	AvailabilityBlockHours = 6
+	AvailabilityBlocks     = 24 / AvailabilityBlockHours // blocks per day
+	HoursPerWeek           = 7 * 24
+)
+
+// availabilityDays are the rows of the grid, Monday first
+var availabilityDays = []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+
+// availabilityBlockTitles are the columns of the grid
+var availabilityBlockTitles = []string{"🌙 0-6", "🌅 6-12", "☀️ 12-18", "🌆 18-24"}
+
+// referenceTimezone is a common timezone, with a city used to detect it from a shared location
+type referenceTimezone struct {
+	Name      string
+	Latitude  float64
+	Longitude float64
+}
+
+// commonTimezones are offered in the timezone picker
+var commonTimezones = []referenceTimezone{
+	{"America/Los_Angeles", 34.05, -118.24},
+	{"America/Denver", 39.74, -104.99},
+	{"America/Chicago", 41.88, -87.63},
+	{"America/New_York", 40.71, -74.01},
+	{"America/Sao_Paulo", -23.55, -46.63},
+	{"Europe/London", 51.51, -0.13},
+	{"Europe/Berlin", 52.52, 13.40},
+	{"Europe/Istanbul", 41.01, 28.98},
+	{"Europe/Moscow", 55.76, 37.62},
+	{"Asia/Tehran", 35.69, 51.39},
+	{"Asia/Dubai", 25.20, 55.27},
+	{"Asia/Kolkata", 28.61, 77.21},
+	{"Asia/Shanghai", 31.23, 121.47},
+	{"Asia/Tokyo", 35.68, 139.69},
+	{"Australia/Sydney", -33.87, 151.21},
+	{"UTC", 51.48, 0},
+}
+
+var shareLocationKeyboard = tgbotapi.NewReplyKeyboard(
+	tgbotapi.NewKeyboardButtonRow(
+		tgbotapi.NewKeyboardButtonLocation("📍 Detect from my location"),
+	),
+	tgbotapi.NewKeyboardButtonRow(
+		tgbotapi.NewKeyboardButton("🏠 Back To Home Menu"),
+	),
+)
+
+// timezoneKeyboard builds the inline picker of common timezones
+func timezoneKeyboard() tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i := 0; i < len(commonTimezones); i += 2 {
+		var row []tgbotapi.InlineKeyboardButton
+		for _, timezone := range commonTimezones[i:min(i+2, len(commonTimezones))] {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(timezone.Name, "tz:"+timezone.Name))
+		}
+		rows = append(rows, row)
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// timezoneFromLocation returns the common timezone closest to the location. When no common timezone is
+// within an hour of longitude, a fixed offset zone is used.
+func timezoneFromLocation(latitude, longitude float64) string {
+	best, bestDistance := "", math.MaxFloat64
+	for _, timezone := range commonTimezones {
+		distance := math.Hypot(timezone.Latitude-latitude, timezone.Longitude-longitude)
+		if distance < bestDistance && math.Abs(timezone.Longitude-longitude) <= 15 {
+			best, bestDistance = timezone.Name, distance
+		}
+	}
+	if best != "" {
+		return best
+	}
+
+	// Etc zones have inverted signs, Etc/GMT-5 is UTC+5
+	offset := int(math.Round(longitude / 15))
+	switch {
+	case offset > 0:
+		return fmt.Sprintf("Etc/GMT-%d", offset)
+	case offset < 0:
+		return fmt.Sprintf("Etc/GMT+%d", -offset)
+	}
+	return "UTC"
+}
+
+// handleEditTimezone shows the timezone picker and asks for the location
+func handleEditTimezone(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	setCurrentEditProfileQuestion(user, "timezone")
+
+	current := user.Timezone
+	if current == "" {
+		current = "not set"
+	}
+	sendMessage(bot, chatID, fmt.Sprintf("🌍 Your timezone: %s\nShare your location to detect it, or type a timezone name like Europe/Paris.", current), shareLocationKeyboard)
+
+	picker := tgbotapi.NewMessage(chatID, "Or choose one of the common timezones:")
+	picker.ReplyMarkup = timezoneKeyboard()
+	bot.Send(picker)
+}
+
+// handleEditProfileTimezone sets the timezone from a shared location or a typed timezone name
+func handleEditProfileTimezone(bot *tgbotapi.BotAPI, update tgbotapi.Update, user *User) {
+	var timezone string
+	if update.Message.Location != nil {
+		storeLocationInDatabase(user, update.Message.Location.Latitude, update.Message.Location.Longitude)
+		timezone = timezoneFromLocation(update.Message.Location.Latitude, update.Message.Location.Longitude)
+	} else {
+		timezone = strings.TrimSpace(update.Message.Text)
+	}
+
+	if !setUserTimezone(user, timezone) {
+		sendMessage(bot, update.Message.Chat.ID, "Unknown timezone. Share your location or choose one of the common timezones.", shareLocationKeyboard)
+		return
+	}
+	setCurrentEditProfileQuestion(user, "empty")
+	sendMessage(bot, update.Message.Chat.ID, fmt.Sprintf("Your timezone has been set to %s", user.Timezone), editProfileMenuKeyboard)
+}
+
+// setUserTimezone validates and stores the timezone of the user
+func setUserTimezone(user *User, timezone string) bool {
+	if timezone == "" {
+		return false
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return false
+	}
+	user.Timezone = timezone
+	if err := db.Model(user).Update("timezone", timezone).Error; err != nil {
+		log.Println("Error saving user timezone:", err)
+		return false
+	}
+	return true
+}
+
+// handleTimezoneCallback handles the buttons of the timezone picker
+func handleTimezoneCallback(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	callback := update.CallbackQuery
+	chatID := callback.Message.Chat.ID
+
+	var user User
+	if err := db.Where("telegram_id = ?", chatID).First(&user).Error; err != nil {
+		log.Printf("Error getting user %d for timezone callback: %v", chatID, err)
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+
+	if !setUserTimezone(&user, strings.TrimPrefix(callback.Data, "tz:")) {
+		log.Println("Invalid timezone callback data:", callback.Data)
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+	setCurrentEditProfileQuestion(&user, "empty")
+	bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "Saved"))
+	bot.Send(tgbotapi.NewEditMessageText(chatID, callback.Message.MessageID, fmt.Sprintf("🌍 Your timezone has been set to %s", user.Timezone)))
+	sendMessage(bot, chatID, "Choose one of the options below:", editProfileMenuKeyboard)
+}
+
+// availabilityBit returns the bit of the day (Monday is 0) and block in the availability mask
+func availabilityBit(day, block int) int64 {
+	return 1 << uint(day*AvailabilityBlocks+block)
+}
+
+// availabilityKeyboard builds the weekly time grid, every cell toggles a block of the user's local time
+func availabilityKeyboard(user *User) tgbotapi.InlineKeyboardMarkup {
+	header := []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData("🕒", "avail:noop")}
+	for _, title := range availabilityBlockTitles {
+		header = append(header, tgbotapi.NewInlineKeyboardButtonData(title, "avail:noop"))
+	}
+	rows := [][]tgbotapi.InlineKeyboardButton{header}
+
+	for day, dayTitle := range availabilityDays {
+		row := []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(dayTitle, "avail:noop")}
+		for block := 0; block < AvailabilityBlocks; block++ {
+			cell := "▫️"
+			if user.Availability&availabilityBit(day, block) != 0 {
+				cell = "✅"
+			}
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(cell, fmt.Sprintf("avail:%d:%d", day, block)))
+		}
+		rows = append(rows, row)
+	}
+
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🧹 Clear", "avail:clear"),
+		tgbotapi.NewInlineKeyboardButtonData("✔️ Done", "avail:done"),
+	))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// handleEditAvailability shows the weekly availability grid
+func handleEditAvailability(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	grid := tgbotapi.NewMessage(chatID, fmt.Sprintf("🕒 When are you free to practice? Tap the blocks of your week (times in %s).",
+		userLocation(user).String()))
+	grid.ReplyMarkup = availabilityKeyboard(user)
+	bot.Send(grid)
+}
+
+// handleAvailabilityCallback handles the cells and buttons of the availability grid
+func handleAvailabilityCallback(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	callback := update.CallbackQuery
+	chatID := callback.Message.Chat.ID
+
+	var user User
+	if err := db.Where("telegram_id = ?", chatID).First(&user).Error; err != nil {
+		log.Printf("Error getting user %d for availability callback: %v", chatID, err)
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+
+	data := strings.TrimPrefix(callback.Data, "avail:")
+	switch data {
+	case "noop":
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	case "done":
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "Saved"))
+		bot.Send(tgbotapi.NewEditMessageText(chatID, callback.Message.MessageID,
+			fmt.Sprintf("🕒 Your availability: %s", availabilityText(&user))))
+		return
+	case "clear":
+		user.Availability = 0
+	default:
+		parts := strings.Split(data, ":")
+		if len(parts) != 2 {
+			log.Println("Invalid availability callback data:", callback.Data)
+			bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+			return
+		}
+		day, dayErr := strconv.Atoi(parts[0])
+		block, blockErr := strconv.Atoi(parts[1])
+		if dayErr != nil || blockErr != nil || day < 0 || day >= len(availabilityDays) || block < 0 || block >= AvailabilityBlocks {
+			log.Println("Invalid availability callback data:", callback.Data)
+			bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+			return
+		}
+		user.Availability ^= availabilityBit(day, block)
+	}
+
+	db.Model(&user).Update("availability", user.Availability)
+	bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+	bot.Send(tgbotapi.NewEditMessageReplyMarkup(chatID, callback.Message.MessageID, availabilityKeyboard(&user)))
+}
+
+// availabilityText summarizes the availability grid, e.g. "Mon 🌅 6-12, ☀️ 12-18; Sat 🌆 18-24"
+func availabilityText(user *User) string {
+	if user.Availability == 0 {
+		return "not set"
+	}
+
+	var days []string
+	for day, dayTitle := range availabilityDays {
+		var blocks []string
+		for block := 0; block < AvailabilityBlocks; block++ {
+			if user.Availability&availabilityBit(day, block) != 0 {
+				blocks = append(blocks, availabilityBlockTitles[block])
+			}
+		}
+		if len(blocks) > 0 {
+			days = append(days, dayTitle+" "+strings.Join(blocks, ", "))
+		}
+	}
+	return strings.Join(days, "; ")
+}
+
+// weeklyUTCHours returns the hours of the week (Monday 00:00 UTC is 0) the user is available,
+// using the current offset of the user's timezone
+func weeklyUTCHours(user *User) [HoursPerWeek]bool {
+	var hours [HoursPerWeek]bool
+	if user.Availability == 0 {
+		return hours
+	}
+
+	_, offset := time.Now().In(userLocation(user)).Zone()
+	offsetHours := int(math.Round(float64(offset) / 3600))
+	for day := range availabilityDays {
+		for block := 0; block < AvailabilityBlocks; block++ {
+			if user.Availability&availabilityBit(day, block) == 0 {
+				continue
+			}
+			for hour := block * AvailabilityBlockHours; hour < (block+1)*AvailabilityBlockHours; hour++ {
+				utcHour := ((day*24+hour-offsetHours)%HoursPerWeek + HoursPerWeek) % HoursPerWeek
+				hours[utcHour] = true
+			}
+		}
+	}
+	return hours
+}
+
+// commonAvailabilityHours counts the hours per week both users are available
+func commonAvailabilityHours(user, partner *User) int {
+	userHours, partnerHours := weeklyUTCHours(user), weeklyUTCHours(partner)
+	count := 0
+	for hour := range userHours {
+		if userHours[hour] && partnerHours[hour] {
+			count++
+		}
+	}
+	return count
+}
+
+// rankPartners orders the partners by the hours per week they share with the user, weighted by their reputation.
+// Partners without common hours are dropped when the user and the partner both set their availability.
+func rankPartners(user *User, partners []*User) []*User {
+	score := make(map[int64]float64, len(partners))
+	ranked := make([]*User, 0, len(partners))
+	for _, partner := range partners {
+		hours := commonAvailabilityHours(user, partner)
+		if hours == 0 && user.Availability != 0 && partner.Availability != 0 {
+			continue
+		}
+		// one extra hour keeps the reputation in the ranking of partners without common hours
+		score[partner.TelegramID] = float64(hours+1) * reputationScore(partner)
+		ranked = append(ranked, partner)
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return score[ranked[i].TelegramID] > score[ranked[j].TelegramID]
+	})
+	return ranked
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// getUserMedia returns the profile photo record of the user, or nil if the user has none
+func getUserMedia(user *User) *Media {
+	if user.MediaID == 0 {
+		return nil
+	}
+
+	var media Media
+	if err := db.First(&media, user.MediaID).Error; err != nil {
+		log.Println("Error getting media record for card:", err)
+		return nil
+	}
+	return &media
+}
+
+// sendUserCard sends a user card to chatID: a photo with caption when the user has a profile photo,
+// otherwise a text message. The same reply markup is attached in both cases.
+func sendUserCard(bot *tgbotapi.BotAPI, chatID int64, user *User, text string, replyMarkup interface{}) (tgbotapi.Message, error) {
+	if media := getUserMedia(user); media != nil {
+		photo := tgbotapi.NewPhotoUpload(chatID, media.Filename)
+		photo.Caption = text
+		if replyMarkup != nil {
+			photo.ReplyMarkup = replyMarkup
+		}
+		sent, err := bot.Send(photo)
+		if err == nil {
+			return sent, nil
+		}
+		// fall back to a text card if the photo could not be sent (e.g. file removed from storage)
+		log.Println("Error sending photo card, falling back to text:", err)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	if replyMarkup != nil {
+		msg.ReplyMarkup = replyMarkup
+	}
+	return bot.Send(msg)
+}
+
+// editUserCard replaces the content of an existing card message in place. Photo cards are edited with
+// editMessageMedia and text cards with editMessageText; when the card type changes the old message is
+// deleted and a new card is sent.
+func editUserCard(bot *tgbotapi.BotAPI, message *tgbotapi.Message, user *User, text string, keyboard tgbotapi.InlineKeyboardMarkup) error {
+	chatID := message.Chat.ID
+	media := getUserMedia(user)
+	isPhotoMessage := message.Photo != nil && len(*message.Photo) > 0
+
+	switch {
+	case media == nil && !isPhotoMessage:
+		edit := tgbotapi.NewEditMessageText(chatID, message.MessageID, text)
+		edit.ReplyMarkup = &keyboard
+		_, err := bot.Send(edit)
+		return err
+	case media != nil && isPhotoMessage:
+		err := editPhotoCard(bot, message, media, text, keyboard)
+		if err == nil {
+			return nil
+		}
+		log.Println("Error editing photo card, sending a new card:", err)
+	}
+
+	bot.DeleteMessage(tgbotapi.NewDeleteMessage(chatID, message.MessageID))
+	_, err := sendUserCard(bot, chatID, user, text, keyboard)
+	return err
+}
+
+// removeInlineKeyboard removes the inline buttons of a message, e.g. after they were answered
+func removeInlineKeyboard(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	empty := tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}
+	bot.Send(tgbotapi.NewEditMessageReplyMarkup(message.Chat.ID, message.MessageID, empty))
+}
+
+// editPhotoCard uploads the media file and replaces the photo and caption of the message
+func editPhotoCard(bot *tgbotapi.BotAPI, message *tgbotapi.Message, media *Media, text string, keyboard tgbotapi.InlineKeyboardMarkup) error {
+	inputMedia, err := json.Marshal(map[string]string{
+		"type":    "photo",
+		"media":   "attach://photo",
+		"caption": text,
+	})
+	if err != nil {
+		return err
+	}
+	replyMarkup, err := json.Marshal(keyboard)
+	if err != nil {
+		return err
+	}
+
+	params := map[string]string{
+		"chat_id":      strconv.FormatInt(message.Chat.ID, 10),
+		"message_id":   strconv.Itoa(message.MessageID),
+		"media":        string(inputMedia),
+		"reply_markup": string(replyMarkup),
+	}
+	_, err = bot.UploadFile("editMessageMedia", params, "photo", media.Filename)
+	return err
+}
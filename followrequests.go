@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// follow request expiry settings
+const (
+	DefaultFollowRequestExpiryDays = 7              // overridden by FOLLOW_REQUEST_EXPIRY_DAYS env
+	FollowRequestReminderAfter     = 48 * time.Hour // target is reminded once after this time
+	FollowRequestRetryCooldown     = 72 * time.Hour // requester can retry this long after the expiry
+)
+
+// the follow request job reminds targets of pending follow requests and expires old ones every 10 minutes
+func init() {
+	registerRecurringJob("follow_requests", "*/10 * * * *", func(bot *tgbotapi.BotAPI) error {
+		if err := remindPendingFollowRequests(bot); err != nil {
+			return err
+		}
+		return expirePendingFollowRequests(bot)
+	})
+}
+
+// followRequestExpiry returns how long a pending follow request stays open
+func followRequestExpiry() time.Duration {
+	days := DefaultFollowRequestExpiryDays
+	if value := os.Getenv("FOLLOW_REQUEST_EXPIRY_DAYS"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err == nil && parsed > 0 {
+			days = parsed
+		} else {
+			log.Println("Invalid FOLLOW_REQUEST_EXPIRY_DAYS, using default:", value)
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// followRequestBlockedText returns why the requester can not send a follow request to the target, or "" if allowed
+func followRequestBlockedText(requesterID, targetID int64) string {
+	var followRequest FollowRequest
+	if err := db.Where("requester_id = ? AND target_id = ?", requesterID, targetID).Order("id desc").First(&followRequest).Error; err != nil {
+		return ""
+	}
+
+	if followRequest.ExpiredAt == nil {
+		return "You have already sent a follow request to this partner."
+	}
+
+	retryAt := followRequest.ExpiredAt.Add(FollowRequestRetryCooldown)
+	if time.Now().Before(retryAt) {
+		return fmt.Sprintf("Your previous follow request expired, you can try again in %s.", formatDuration(time.Until(retryAt)))
+	}
+	return ""
+}
+
+// remindPendingFollowRequests sends one reminder to the target of every pending follow request older than FollowRequestReminderAfter
+func remindPendingFollowRequests(bot *tgbotapi.BotAPI) error {
+	var followRequests []FollowRequest
+	if err := db.Where("accepted = ? AND expired_at IS NULL AND reminded_at IS NULL AND created_at < ?",
+		false, time.Now().Add(-FollowRequestReminderAfter)).Find(&followRequests).Error; err != nil {
+		return err
+	}
+
+	for _, followRequest := range followRequests {
+		// the request may have been answered since it was loaded
+		now := time.Now()
+		result := db.Model(&FollowRequest{}).Where("id = ? AND accepted = ? AND expired_at IS NULL AND reminded_at IS NULL", followRequest.ID, false).
+			Update("reminded_at", &now)
+		if result.Error != nil || result.RowsAffected != 1 {
+			continue
+		}
+
+		var requester User
+		if err := db.Where("telegram_id = ?", followRequest.RequesterID).First(&requester).Error; err != nil {
+			continue
+		}
+
+		left := followRequestExpiry() - time.Since(followRequest.CreatedAt)
+		messageText := fmt.Sprintf("⏰ Reminder: %s is waiting for your answer. The request expires in %s.\nEnglish Level: %s\n",
+			requester.Name, formatDuration(left), requester.EnglishLevel)
+		target := User{TelegramID: followRequest.TargetID}
+		if _, err := sendUserCard(bot, followRequest.TargetID, cardUserFor(&requester, &target), messageText, followRequestKeyboard(requester.TelegramID)); err != nil {
+			log.Println("Error sending follow request reminder:", err)
+		}
+	}
+	return nil
+}
+
+// expirePendingFollowRequests expires pending follow requests older than the expiry and notifies the requesters
+func expirePendingFollowRequests(bot *tgbotapi.BotAPI) error {
+	var followRequests []FollowRequest
+	if err := db.Where("accepted = ? AND expired_at IS NULL AND created_at < ?",
+		false, time.Now().Add(-followRequestExpiry())).Find(&followRequests).Error; err != nil {
+		return err
+	}
+
+	for _, followRequest := range followRequests {
+		// a request accepted since it was loaded must not expire
+		now := time.Now()
+		result := db.Model(&FollowRequest{}).Where("id = ? AND accepted = ? AND expired_at IS NULL", followRequest.ID, false).
+			Update("expired_at", &now)
+		if result.Error != nil || result.RowsAffected != 1 {
+			continue
+		}
+
+		var target User
+		if err := db.Where("telegram_id = ?", followRequest.TargetID).First(&target).Error; err != nil {
+			continue
+		}
+
+		sendMessage(bot, followRequest.RequesterID,
+			fmt.Sprintf("⌛ Your follow request to %s expired without an answer. You can send a new one after %s.",
+				target.Name, formatDuration(FollowRequestRetryCooldown)), mainKeyboard)
+	}
+	return nil
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// activity kinds and the leaderboard points they earn
+const (
+	ActivitySession = "session" // practice session, practice now chat or group practice
+	ActivityMessage = "message" // message relayed to a partner
+	ActivityTopic   = "topic"   // conversation topic used with a partner
+)
+
+var activityPoints = map[string]int{
+	ActivitySession: 10,
+	ActivityMessage: 1,
+	ActivityTopic:   2,
+}
+
+// gamification settings
+const (
+	MessagePointsDailyCap = 20                  // message points a user can earn per day, so spamming the relay does not pay
+	LeaderboardSize       = 10                  // users shown on the leaderboard
+	ActivityRetention     = 90 * 24 * time.Hour // activity events older than this are removed by the weekly job
+	dayLayout             = "2006-01-02"
+	testedBadgeKey        = "tested"
+)
+
+// ActivityEvent is one practice activity of a user, streaks, badges and the leaderboard are computed from it
+type ActivityEvent struct {
+	ID        uint   `gorm:"primary_key"`
+	UserID    int64  `gorm:"index"` // telegram ID of the user
+	Kind      string // one of the Activity kinds
+	CreatedAt time.Time
+}
+
+// UserBadge is a badge earned by a user
+type UserBadge struct {
+	ID        uint   `gorm:"primary_key"`
+	UserID    int64  `gorm:"unique_index:idx_badge_user_badge"` // telegram ID of the user
+	Badge     string `gorm:"unique_index:idx_badge_user_badge"` // badge key, see badges
+	Title     string // badge title shown on the profile
+	CreatedAt time.Time
+}
+
+// LeaderboardEntry is the rank of an opted-in user in the leaderboard of a week
+type LeaderboardEntry struct {
+	ID     uint   `gorm:"primary_key"`
+	Week   string `gorm:"index"` // start of the week (Monday, UTC) in dayLayout
+	UserID int64  // telegram ID of the user
+	Points int
+	Rank   int
+}
+
+// badge describes a badge, earned returns the badge key and title if the user deserves it
+type badge struct {
+	earned func(user *User) (key, title string, ok bool)
+}
+
+// badges lists the badges users can earn
+var badges = []badge{
+	{func(user *User) (string, string, bool) {
+		return "streak_7", "🔥 7-day streak", user.LongestStreak >= 7
+	}},
+	{func(user *User) (string, string, bool) {
+		return "streak_30", "🔥 30-day streak", user.LongestStreak >= 30
+	}},
+	{func(user *User) (string, string, bool) {
+		return "partners_10", "🤝 10 partners", len(getConnectedPartnerIDs(user.TelegramID)) >= 10
+	}},
+	{func(user *User) (string, string, bool) {
+		var count int
+		db.Model(&ActivityEvent{}).Where("user_id = ? AND kind = ?", user.TelegramID, ActivitySession).Count(&count)
+		return "sessions_10", "🎙️ 10 sessions", count >= 10
+	}},
+	// one tested badge, a retake at another level changes its title
+	{func(user *User) (string, string, bool) {
+		return testedBadgeKey, "✔️ tested " + string(user.PlacementLevel), user.PlacementTestedAt != nil && user.PlacementLevel.Valid()
+	}},
+}
+
+// the leaderboard job updates the standings of the current week every hour,
+// the weekly job announces the final standings of the past week
+func init() {
+	registerRecurringJob("leaderboard", "5 * * * *", func(bot *tgbotapi.BotAPI) error {
+		_, err := computeLeaderboard(weekStart(time.Now()))
+		return err
+	})
+	registerRecurringJob("leaderboard_weekly", "15 0 * * 1", announceLeaderboard)
+}
+
+// recordActivity stores an activity of the user, updates the streak and awards new badges
+func recordActivity(bot *tgbotapi.BotAPI, telegramID int64, kind string) {
+	if err := db.Create(&ActivityEvent{UserID: telegramID, Kind: kind}).Error; err != nil {
+		log.Println("Error recording activity:", err)
+		return
+	}
+
+	var user User
+	if err := db.Where("telegram_id = ?", telegramID).First(&user).Error; err != nil {
+		return
+	}
+
+	// streak days follow the local calendar of the user
+	local := time.Now().In(userLocation(&user))
+	today := local.Format(dayLayout)
+	if user.LastActiveOn != today {
+		if user.LastActiveOn == local.AddDate(0, 0, -1).Format(dayLayout) {
+			user.StreakDays++
+		} else {
+			user.StreakDays = 1
+		}
+		user.LongestStreak = max(user.LongestStreak, user.StreakDays)
+		user.LastActiveOn = today
+		db.Model(&user).Updates(map[string]interface{}{
+			"streak_days":    user.StreakDays,
+			"longest_streak": user.LongestStreak,
+			"last_active_on": user.LastActiveOn,
+		})
+	}
+
+	awardBadges(bot, &user)
+}
+
+// awardBadges stores the badges the user deserves and congratulates the user on new ones,
+// owned badges whose title changed are updated
+func awardBadges(bot *tgbotapi.BotAPI, user *User) {
+	var userBadges []UserBadge
+	db.Where("user_id = ?", user.TelegramID).Find(&userBadges)
+	owned := map[string]*UserBadge{}
+	for i := range userBadges {
+		owned[userBadges[i].Badge] = &userBadges[i]
+	}
+
+	for _, b := range badges {
+		key, title, ok := b.earned(user)
+		if !ok {
+			continue
+		}
+		if userBadge := owned[key]; userBadge != nil {
+			if userBadge.Title != title {
+				db.Model(userBadge).Update("title", title)
+				sendMessage(bot, user.TelegramID, fmt.Sprintf("🏅 Your badge is now %s.", title), nil)
+			}
+			continue
+		}
+		if err := db.Create(&UserBadge{UserID: user.TelegramID, Badge: key, Title: title}).Error; err != nil {
+			log.Println("Error awarding badge:", err)
+			continue
+		}
+		sendMessage(bot, user.TelegramID, fmt.Sprintf("🏅 New badge: %s! Keep practicing.", title), nil)
+	}
+}
+
+// migrateTestedBadges merges the former per level tested badges (tested_<level>) into the single tested badge,
+// keeping the title of the latest one
+func migrateTestedBadges() {
+	if err := db.Exec(`DELETE FROM user_badges WHERE badge LIKE 'tested\_%' AND EXISTS (
+		SELECT 1 FROM user_badges newer WHERE newer.user_id = user_badges.user_id AND newer.badge LIKE 'tested\_%' AND newer.id > user_badges.id)`).Error; err != nil {
+		log.Println("Error migrating tested badges:", err)
+		return
+	}
+	if err := db.Model(&UserBadge{}).Where("badge LIKE ?", `tested\_%`).Update("badge", testedBadgeKey).Error; err != nil {
+		log.Println("Error migrating tested badges:", err)
+	}
+}
+
+// currentStreak returns the streak of the user, a streak is broken when the user was not active yesterday or today
+func currentStreak(user *User) int {
+	local := time.Now().In(userLocation(user))
+	if user.LastActiveOn != local.Format(dayLayout) && user.LastActiveOn != local.AddDate(0, 0, -1).Format(dayLayout) {
+		return 0
+	}
+	return user.StreakDays
+}
+
+// achievementsText describes the streak and the badges of the user for the profile
+func achievementsText(user *User) string {
+	text := fmt.Sprintf("🔥 Streak: %d days (longest %d)", currentStreak(user), user.LongestStreak)
+
+	var titles []string
+	db.Model(&UserBadge{}).Where("user_id = ?", user.TelegramID).Order("created_at").Pluck("title", &titles)
+	if len(titles) > 0 {
+		text += "\n🏅 Badges: " + strings.Join(titles, ", ")
+	}
+	return text
+}
+
+// weekStart returns the start of the week (Monday 00:00 UTC) of t
+func weekStart(t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+// computeLeaderboard recomputes the leaderboard of the week from the activity events of the opted-in users
+func computeLeaderboard(week time.Time) ([]LeaderboardEntry, error) {
+	var rows []struct {
+		UserID int64
+		Kind   string
+		Day    string
+		Count  int
+	}
+	err := db.Raw(`SELECT activity_events.user_id, activity_events.kind, to_char(activity_events.created_at, 'YYYY-MM-DD') AS day, count(*) AS count
+		FROM activity_events JOIN users ON users.telegram_id = activity_events.user_id AND users.deleted_at IS NULL
+		WHERE users.leaderboard = ? AND activity_events.created_at >= ? AND activity_events.created_at < ?
+		GROUP BY 1, 2, 3`, true, week, week.AddDate(0, 0, 7)).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	points := map[int64]int{}
+	for _, row := range rows {
+		earned := row.Count * activityPoints[row.Kind]
+		if row.Kind == ActivityMessage {
+			earned = min(earned, MessagePointsDailyCap)
+		}
+		points[row.UserID] += earned
+	}
+
+	entries := make([]LeaderboardEntry, 0, len(points))
+	for userID, total := range points {
+		entries = append(entries, LeaderboardEntry{Week: week.Format(dayLayout), UserID: userID, Points: total})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Points != entries[j].Points {
+			return entries[i].Points > entries[j].Points
+		}
+		return entries[i].UserID < entries[j].UserID
+	})
+
+	tx := db.Begin()
+	tx.Where("week = ?", week.Format(dayLayout)).Delete(&LeaderboardEntry{})
+	for i := range entries {
+		entries[i].Rank = i + 1
+		if err := tx.Create(&entries[i]).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	return entries, tx.Commit().Error
+}
+
+// leaderboardText formats the top of the leaderboard of the week and the rank of the user
+func leaderboardText(title string, week time.Time, telegramID int64) string {
+	var entries []LeaderboardEntry
+	db.Where("week = ?", week.Format(dayLayout)).Order("rank").Find(&entries)
+	if len(entries) == 0 {
+		return title + "\n\nNo activity yet, practice to earn points!"
+	}
+
+	lines := []string{title, ""}
+	var own *LeaderboardEntry
+	for i := range entries {
+		entry := &entries[i]
+		if entry.UserID == telegramID {
+			own = entry
+		}
+		if entry.Rank > LeaderboardSize {
+			continue
+		}
+		var user User
+		db.Where("telegram_id = ?", entry.UserID).First(&user)
+		lines = append(lines, fmt.Sprintf("%d. %s — %d points", entry.Rank, user.Name, entry.Points))
+	}
+	if own != nil {
+		lines = append(lines, "", fmt.Sprintf("Your rank: %d (%d points)", own.Rank, own.Points))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// handleLeaderboardCommand shows the weekly leaderboard with the opt-in switch
+func handleLeaderboardCommand(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	text := leaderboardText("🏆 Leaderboard of this week (updated every hour)", weekStart(time.Now()), user.TelegramID)
+	text += fmt.Sprintf("\n\nPoints: session %d, topic %d, message %d (up to %d a day).",
+		activityPoints[ActivitySession], activityPoints[ActivityTopic], activityPoints[ActivityMessage], MessagePointsDailyCap)
+
+	button := tgbotapi.NewInlineKeyboardButtonData("🏆 Join the leaderboard", "lb:join")
+	if user.Leaderboard {
+		button = tgbotapi.NewInlineKeyboardButtonData("🙈 Leave the leaderboard", "lb:leave")
+	} else {
+		text += "\nYou are not on the leaderboard, only users who join are shown with their name."
+	}
+	sendMessage(bot, chatID, text, tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(button)))
+}
+
+// handleLeaderboardCallback joins or leaves the leaderboard
+func handleLeaderboardCallback(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	callback := update.CallbackQuery
+	join := callback.Data == "lb:join"
+	db.Model(&User{}).Where("telegram_id = ?", callback.Message.Chat.ID).Update("leaderboard", join)
+
+	if join {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "You joined the leaderboard, standings are updated every hour."))
+	} else {
+		// leaving removes the user from the standings right away
+		db.Where("user_id = ?", callback.Message.Chat.ID).Delete(&LeaderboardEntry{})
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "You left the leaderboard."))
+	}
+	removeInlineKeyboard(bot, callback.Message)
+}
+
+// announceLeaderboard computes the final leaderboard of the past week, sends it to the participants
+// and removes old activity events
+func announceLeaderboard(bot *tgbotapi.BotAPI) error {
+	week := weekStart(time.Now()).AddDate(0, 0, -7)
+	entries, err := computeLeaderboard(week)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		sendMessage(bot, entry.UserID, leaderboardText("🏆 Final leaderboard of last week", week, entry.UserID), mainKeyboard)
+	}
+
+	return db.Where("created_at < ?", time.Now().Add(-ActivityRetention)).Delete(&ActivityEvent{}).Error
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// EnglishLanguage is the language code of English, its level is also stored in User.EnglishLevel
+const EnglishLanguage = "en"
+
+// MaxLanguagesPerKind is the number of native and the number of target languages a user can have
+const MaxLanguagesPerKind = 3
+
+// language is a supported language with its display name
+type language struct {
+	Code string // ISO 639-1 code
+	Name string // name with flag, e.g. "🇪🇸 Spanish"
+}
+
+// supportedLanguages are offered in the language picker
+var supportedLanguages = []language{
+	{"en", "🇬🇧 English"},
+	{"es", "🇪🇸 Spanish"},
+	{"fr", "🇫🇷 French"},
+	{"de", "🇩🇪 German"},
+	{"it", "🇮🇹 Italian"},
+	{"pt", "🇵🇹 Portuguese"},
+	{"ru", "🇷🇺 Russian"},
+	{"tr", "🇹🇷 Turkish"},
+	{"ar", "🇸🇦 Arabic"},
+	{"fa", "🇮🇷 Persian"},
+	{"zh", "🇨🇳 Chinese"},
+	{"ja", "🇯🇵 Japanese"},
+	{"ko", "🇰🇷 Korean"},
+}
+
+// languageName returns the display name of the language code
+func languageName(code string) string {
+	for _, supported := range supportedLanguages {
+		if supported.Code == code {
+			return supported.Name
+		}
+	}
+	return code
+}
+
+// isSupportedLanguage checks the language code is in supportedLanguages
+func isSupportedLanguage(code string) bool {
+	return languageName(code) != code
+}
+
+// UserLanguage is a native language or a target language (being learned, with a level) of a user
+type UserLanguage struct {
+	ID        uint          `gorm:"primary_key"`
+	UserID    int64         `gorm:"unique_index:idx_user_language"` // telegram ID of the user
+	Language  string        `gorm:"unique_index:idx_user_language"` // ISO 639-1 code
+	Native    bool          // native language, otherwise a target language
+	Level     LanguageLevel // level of a target language
+	CreatedAt time.Time
+}
+
+// getUserLanguages returns the native and the target languages of the user
+func getUserLanguages(telegramID int64) (natives, targets []UserLanguage) {
+	var languages []UserLanguage
+	if err := db.Where("user_id = ?", telegramID).Order("id").Find(&languages).Error; err != nil {
+		log.Println("Error querying database for user languages:", err)
+		return nil, nil
+	}
+	for _, userLanguage := range languages {
+		if userLanguage.Native {
+			natives = append(natives, userLanguage)
+		} else {
+			targets = append(targets, userLanguage)
+		}
+	}
+	return natives, targets
+}
+
+// setUserLanguage adds or replaces a language of the user, a language is either native or a target
+func setUserLanguage(telegramID int64, code string, native bool, level LanguageLevel) error {
+	userLanguage := UserLanguage{UserID: telegramID, Language: code}
+	if err := db.Where(userLanguage).FirstOrInit(&userLanguage).Error; err != nil {
+		return err
+	}
+	userLanguage.Native = native
+	userLanguage.Level = level
+	if native {
+		userLanguage.Level = ""
+	}
+	if err := db.Save(&userLanguage).Error; err != nil {
+		return err
+	}
+
+	// English is also stored on the user for placement tests and topics
+	if code == EnglishLanguage {
+		return db.Model(&User{}).Where("telegram_id = ?", telegramID).Update("english_level", userLanguage.Level).Error
+	}
+	return nil
+}
+
+// syncEnglishLevel stores the English level of the user as an English target language,
+// unless English is the native language of the user
+func syncEnglishLevel(telegramID int64, level LanguageLevel) {
+	if !level.Valid() {
+		return
+	}
+	var userLanguage UserLanguage
+	if err := db.Where("user_id = ? AND language = ?", telegramID, EnglishLanguage).First(&userLanguage).Error; err == nil && userLanguage.Native {
+		return
+	}
+	if err := setUserLanguage(telegramID, EnglishLanguage, false, level); err != nil {
+		log.Println("Error storing english level as user language:", err)
+	}
+}
+
+// migrateUserLanguages adds the English target language to users registered before languages existed
+func migrateUserLanguages() {
+	err := db.Exec(`INSERT INTO user_languages (user_id, language, native, level, created_at)
+		SELECT telegram_id, ?, false, english_level, now() FROM users
+		WHERE deleted_at IS NULL AND english_level IN (?)
+		AND NOT EXISTS (SELECT 1 FROM user_languages WHERE user_languages.user_id = users.telegram_id AND language = ?)`,
+		EnglishLanguage, levelsOrdered, EnglishLanguage).Error
+	if err != nil {
+		log.Println("Error migrating user languages:", err)
+	}
+}
+
+// languagesText describes the languages of the user, e.g. "Native: 🇪🇸 Spanish\nLearning: 🇬🇧 English B1"
+func languagesText(telegramID int64) string {
+	natives, targets := getUserLanguages(telegramID)
+
+	nativeNames := make([]string, 0, len(natives))
+	for _, userLanguage := range natives {
+		nativeNames = append(nativeNames, languageName(userLanguage.Language))
+	}
+	targetNames := make([]string, 0, len(targets))
+	for _, userLanguage := range targets {
+		targetNames = append(targetNames, fmt.Sprintf("%s %s", languageName(userLanguage.Language), string(userLanguage.Level)))
+	}
+
+	text := "Native: not set"
+	if len(nativeNames) > 0 {
+		text = "Native: " + strings.Join(nativeNames, ", ")
+	}
+	if len(targetNames) > 0 {
+		text += "\nLearning: " + strings.Join(targetNames, ", ")
+	}
+	return text
+}
+
+// languagesKeyboard builds the language editor, every language has a remove button
+func languagesKeyboard(telegramID int64) tgbotapi.InlineKeyboardMarkup {
+	natives, targets := getUserLanguages(telegramID)
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, userLanguage := range append(natives, targets...) {
+		title := fmt.Sprintf("❌ %s (native)", languageName(userLanguage.Language))
+		if !userLanguage.Native {
+			title = fmt.Sprintf("❌ %s %s", languageName(userLanguage.Language), string(userLanguage.Level))
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(title, "lang:del:"+userLanguage.Language),
+		))
+	}
+
+	var add []tgbotapi.InlineKeyboardButton
+	if len(natives) < MaxLanguagesPerKind {
+		add = append(add, tgbotapi.NewInlineKeyboardButtonData("➕ Native language", "lang:pick:native"))
+	}
+	if len(targets) < MaxLanguagesPerKind {
+		add = append(add, tgbotapi.NewInlineKeyboardButtonData("➕ Learning language", "lang:pick:target"))
+	}
+	if len(add) > 0 {
+		rows = append(rows, add)
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// languagePickerKeyboard builds the picker of a native or a target language
+func languagePickerKeyboard(kind string) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+	for _, supported := range supportedLanguages {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(supported.Name, fmt.Sprintf("lang:add:%s:%s", kind, supported.Code)))
+		if len(row) == 3 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// languageLevelKeyboard builds the level picker of a target language
+func languageLevelKeyboard(code string) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i := 0; i < len(levelsOrdered); i += 3 {
+		var row []tgbotapi.InlineKeyboardButton
+		for _, level := range levelsOrdered[i : i+3] {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(level.String(), fmt.Sprintf("lang:level:%s:%s", code, string(level))))
+		}
+		rows = append(rows, row)
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// handleEditLanguages shows the language editor
+func handleEditLanguages(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	msg := tgbotapi.NewMessage(chatID, "🗣️ Your languages:\n"+languagesText(user.TelegramID))
+	msg.ReplyMarkup = languagesKeyboard(user.TelegramID)
+	bot.Send(msg)
+}
+
+// handleLanguageCallback handles the buttons of the language editor
+func handleLanguageCallback(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	callback := update.CallbackQuery
+	chatID := callback.Message.Chat.ID
+	parts := strings.Split(strings.TrimPrefix(callback.Data, "lang:"), ":")
+
+	var user User
+	if err := db.Where("telegram_id = ?", chatID).First(&user).Error; err != nil || len(parts) < 2 {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+
+	editMessage := func(text string, keyboard tgbotapi.InlineKeyboardMarkup) {
+		edit := tgbotapi.NewEditMessageText(chatID, callback.Message.MessageID, text)
+		edit.ReplyMarkup = &keyboard
+		bot.Send(edit)
+	}
+	showEditor := func() {
+		editMessage("🗣️ Your languages:\n"+languagesText(user.TelegramID), languagesKeyboard(user.TelegramID))
+	}
+
+	switch {
+	case parts[0] == "pick" && (parts[1] == "native" || parts[1] == "target"):
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		editMessage(fmt.Sprintf("Choose your %s language:", parts[1]), languagePickerKeyboard(parts[1]))
+	case parts[0] == "add" && len(parts) == 3 && isSupportedLanguage(parts[2]):
+		natives, targets := getUserLanguages(user.TelegramID)
+		if (parts[1] == "native" && len(natives) >= MaxLanguagesPerKind) || (parts[1] == "target" && len(targets) >= MaxLanguagesPerKind) {
+			bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, fmt.Sprintf("You can have up to %d %s languages.", MaxLanguagesPerKind, parts[1])))
+			showEditor()
+			return
+		}
+
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		if parts[1] == "target" {
+			editMessage(fmt.Sprintf("What's your level in %s?", languageName(parts[2])), languageLevelKeyboard(parts[2]))
+			return
+		}
+		if err := setUserLanguage(user.TelegramID, parts[2], true, ""); err != nil {
+			log.Println("Error saving native language:", err)
+		}
+		showEditor()
+	case parts[0] == "level" && len(parts) == 3 && isSupportedLanguage(parts[1]):
+		level, ok := parseLevel(parts[2])
+		if !ok {
+			bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+			return
+		}
+		if err := setUserLanguage(user.TelegramID, parts[1], false, level); err != nil {
+			log.Println("Error saving target language:", err)
+		}
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "Saved"))
+		showEditor()
+	case parts[0] == "del":
+		db.Where("user_id = ? AND language = ?", user.TelegramID, parts[1]).Delete(&UserLanguage{})
+		if parts[1] == EnglishLanguage {
+			db.Model(&user).Update("english_level", "")
+		}
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "Removed"))
+		showEditor()
+	default:
+		log.Println("Invalid language callback data:", callback.Data)
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+	}
+}
+
+// languageFilterOption is a choice of the language question of find partner
+type languageFilterOption struct {
+	Text     string
+	Language string
+	Exchange bool // natives of the language learning a native language of the user
+}
+
+// languageFilterOptions returns the language choices of the user: learners of every target language,
+// and an exchange for every target language when the user has a native language
+func languageFilterOptions(telegramID int64) []languageFilterOption {
+	natives, targets := getUserLanguages(telegramID)
+	if len(targets) == 0 {
+		targets = []UserLanguage{{Language: EnglishLanguage}}
+	}
+
+	var options []languageFilterOption
+	for _, target := range targets {
+		options = append(options, languageFilterOption{
+			Text:     fmt.Sprintf("%s learners", languageName(target.Language)),
+			Language: target.Language,
+		})
+		if len(natives) > 0 {
+			options = append(options, languageFilterOption{
+				Text:     fmt.Sprintf("🔁 %s exchange", languageName(target.Language)),
+				Language: target.Language,
+				Exchange: true,
+			})
+		}
+	}
+	return options
+}
+
+// languageFilterKeyboard builds the language question of find partner
+func languageFilterKeyboard(options []languageFilterOption) tgbotapi.ReplyKeyboardMarkup {
+	var rows [][]tgbotapi.KeyboardButton
+	for _, option := range options {
+		rows = append(rows, tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton(option.Text)))
+	}
+	return tgbotapi.NewReplyKeyboard(rows...)
+}
+
+// handleLanguageFilter processes the user's language filter response
+func handleLanguageFilter(bot *tgbotapi.BotAPI, update tgbotapi.Update, user *User) {
+	for _, option := range languageFilterOptions(user.TelegramID) {
+		if option.Text == update.Message.Text {
+			applyLanguageFilter(bot, update.Message.Chat.ID, user, option)
+			return
+		}
+	}
+	sendErrorMessage(bot, update.Message.Chat.ID, "Invalid language option. Please select one of the buttons.")
+}
+
+// applyLanguageFilter stores the language filter and asks the next filter question,
+// partners of an exchange are natives so the level question is skipped
+func applyLanguageFilter(bot *tgbotapi.BotAPI, chatID int64, user *User, option languageFilterOption) {
+	user.LastSelectedLanguage = option.Language
+	user.LastSelectedExchange = option.Exchange
+
+	if option.Exchange {
+		user.CurrentFindPartnerQuestion = 1001
+		db.Save(user)
+		sendMessage(bot, chatID, "What's the preferred gender of your potential partner?", selectGenderFilterKeyboard)
+		return
+	}
+
+	setCurrentFindPartnerQuestion(user)
+	sendMessage(bot, chatID, fmt.Sprintf("What's the preferred %s level of your potential partner? Choose a level or a range, or type one like B1–B2.",
+		languageName(option.Language)), levelFilterKeyboard())
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// LanguageLevel is a CEFR level of a language, stored by its code
+type LanguageLevel string
+
+// CEFR levels
+const (
+	LevelA1 LanguageLevel = "A1"
+	LevelA2 LanguageLevel = "A2"
+	LevelB1 LanguageLevel = "B1"
+	LevelB2 LanguageLevel = "B2"
+	LevelC1 LanguageLevel = "C1"
+	LevelC2 LanguageLevel = "C2"
+)
+
+// levelsOrdered are the CEFR levels, from the lowest to the highest
+var levelsOrdered = []LanguageLevel{LevelA1, LevelA2, LevelB1, LevelB2, LevelC1, LevelC2}
+
+// levelBands are the coarse labels of the levels, every band covers two CEFR levels
+var levelBands = []string{"Beginner", "Intermediate", "Advanced"}
+
+// Rank returns the index of the level from A1 (0) to C2 (5), or -1 if the level is not valid
+func (l LanguageLevel) Rank() int {
+	for i, level := range levelsOrdered {
+		if level == l {
+			return i
+		}
+	}
+	return -1
+}
+
+// Valid checks if the level is a CEFR level
+func (l LanguageLevel) Valid() bool {
+	return l.Rank() >= 0
+}
+
+// Band returns the coarse label of the level, e.g. "Intermediate" for B2
+func (l LanguageLevel) Band() string {
+	if !l.Valid() {
+		return ""
+	}
+	return levelBands[l.Rank()/2]
+}
+
+// String returns the level with its band, e.g. "B2 Intermediate"
+func (l LanguageLevel) String() string {
+	if !l.Valid() {
+		return "not set"
+	}
+	return string(l) + " " + l.Band()
+}
+
+// parseLevel parses a CEFR code or a level button text like "B2 Intermediate"
+func parseLevel(text string) (LanguageLevel, bool) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return "", false
+	}
+	level := LanguageLevel(strings.ToUpper(fields[0]))
+	return level, level.Valid()
+}
+
+// bandRange returns the CEFR levels covered by a coarse label
+func bandRange(band string) (LanguageLevel, LanguageLevel, bool) {
+	for i, levelBand := range levelBands {
+		if strings.EqualFold(levelBand, band) {
+			return levelsOrdered[i*2], levelsOrdered[i*2+1], true
+		}
+	}
+	return "", "", false
+}
+
+// levelRangeSeparators are accepted between the two levels of a range
+var levelRangeSeparators = []string{"–", "-", " to "}
+
+// parseLevelRange parses a level filter: a range like "B1–B2", a single level, a band label or any level
+func parseLevelRange(text string) (LanguageLevel, LanguageLevel, bool) {
+	text = strings.TrimSpace(text)
+	if text == anyLevelText {
+		return levelsOrdered[0], levelsOrdered[len(levelsOrdered)-1], true
+	}
+	if min, max, ok := bandRange(text); ok {
+		return min, max, true
+	}
+	// the filter buttons show the band after the range, e.g. "B1–B2 Intermediate"
+	if fields := strings.Fields(text); len(fields) > 1 {
+		if _, _, ok := bandRange(fields[len(fields)-1]); ok {
+			text = strings.Join(fields[:len(fields)-1], " ")
+		}
+	}
+
+	for _, separator := range levelRangeSeparators {
+		if parts := strings.SplitN(text, separator, 2); len(parts) == 2 {
+			min := LanguageLevel(strings.ToUpper(strings.TrimSpace(parts[0])))
+			max := LanguageLevel(strings.ToUpper(strings.TrimSpace(parts[1])))
+			if !min.Valid() || !max.Valid() {
+				return "", "", false
+			}
+			if min.Rank() > max.Rank() {
+				min, max = max, min
+			}
+			return min, max, true
+		}
+	}
+
+	level, ok := parseLevel(text)
+	return level, level, ok
+}
+
+// levelRangeText formats a level filter, e.g. "B1–B2" or "B1" for a single level
+func levelRangeText(min, max LanguageLevel) string {
+	if min == max {
+		return string(min)
+	}
+	return string(min) + "–" + string(max)
+}
+
+// levelsBetween returns the levels from min to max, both included
+func levelsBetween(min, max LanguageLevel) []LanguageLevel {
+	if !min.Valid() || !max.Valid() {
+		return nil
+	}
+	return levelsOrdered[min.Rank() : max.Rank()+1]
+}
+
+// anyLevelText is the filter button matching all levels
+const anyLevelText = "🌐 Any level"
+
+// levelButtonRows builds the CEFR level buttons, shared by registration and edit profile
+func levelButtonRows() [][]tgbotapi.KeyboardButton {
+	var rows [][]tgbotapi.KeyboardButton
+	for i := 0; i < len(levelsOrdered); i += 3 {
+		var row []tgbotapi.KeyboardButton
+		for _, level := range levelsOrdered[i : i+3] {
+			row = append(row, tgbotapi.NewKeyboardButton(level.String()))
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// levelFilterKeyboard builds the level range filter of find partner
+func levelFilterKeyboard() tgbotapi.ReplyKeyboardMarkup {
+	var bands []tgbotapi.KeyboardButton
+	for _, band := range levelBands {
+		min, max, _ := bandRange(band)
+		bands = append(bands, tgbotapi.NewKeyboardButton(levelRangeText(min, max)+" "+band))
+	}
+
+	rows := levelButtonRows()
+	rows = append(rows, bands, tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton(anyLevelText)))
+	return tgbotapi.NewReplyKeyboard(rows...)
+}
+
+// legacyEnglishLevels maps the former three-string levels to the lowest CEFR level of their band
+var legacyEnglishLevels = map[string]LanguageLevel{
+	"Beginner":     LevelA1,
+	"Intermediate": LevelB1,
+	"Advanced":     LevelC1,
+}
+
+// migrateEnglishLevels converts the former three-string levels of existing rows to CEFR levels,
+// and the former level filters to level ranges
+func migrateEnglishLevels() {
+	for legacy, level := range legacyEnglishLevels {
+		if err := db.Model(&User{}).Where("english_level = ?", legacy).Update("english_level", level).Error; err != nil {
+			log.Println("Error migrating english levels:", err)
+		}
+
+		min, max, _ := bandRange(legacy)
+		if err := db.Model(&User{}).Where("last_selected_english_level = ?", legacy).
+			Update("last_selected_english_level", levelRangeText(min, max)).Error; err != nil {
+			log.Println("Error migrating english level filters:", err)
+		}
+	}
+}
+
+// levelHelpText lists the valid levels for error messages
+func levelHelpText() string {
+	levels := make([]string, 0, len(levelsOrdered))
+	for _, level := range levelsOrdered {
+		levels = append(levels, string(level))
+	}
+	return fmt.Sprintf("Please select one of %s.", strings.Join(levels, ", "))
+}
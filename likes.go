@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/jinzhu/gorm"
+)
+
+// PartnerLike stores a silent like of a partner card, a match happens when both users like each other
+type PartnerLike struct {
+	ID        uint  `gorm:"primary_key"`
+	UserID    int64 `gorm:"unique_index:idx_like_user_liked"` // ID of the user who liked
+	LikedID   int64 `gorm:"unique_index:idx_like_user_liked"` // ID of the liked partner
+	CreatedAt time.Time
+}
+
+// likePartner records the like and returns true if the partner already liked the user (a match)
+func likePartner(userID, partnerID int64) bool {
+	like := PartnerLike{UserID: userID, LikedID: partnerID}
+	if err := db.Where(like).FirstOrCreate(&like).Error; err != nil {
+		log.Println("Error creating partner like:", err)
+		return false
+	}
+
+	var count int
+	db.Model(&PartnerLike{}).Where("user_id = ? AND liked_id = ?", partnerID, userID).Count(&count)
+	return count > 0
+}
+
+// connectPartners stores an accepted connection between two users, the same way an accepted follow request does
+func connectPartners(requesterID, targetID int64) {
+	var followRequest FollowRequest
+	err := db.Where("(requester_id = ? AND target_id = ?) OR (requester_id = ? AND target_id = ?)",
+		requesterID, targetID, targetID, requesterID).First(&followRequest).Error
+	if err == nil {
+		// an expired request becomes a connection, it must not be treated as expired anymore
+		db.Model(&followRequest).Updates(map[string]interface{}{"accepted": true, "expired_at": gorm.Expr("NULL")})
+	} else {
+		followRequest = FollowRequest{RequesterID: requesterID, TargetID: targetID, Accepted: true}
+		if err := db.Create(&followRequest).Error; err != nil {
+			log.Println("Error creating connection for match:", err)
+			return
+		}
+	}
+	scheduleRatingPrompts(requesterID, targetID)
+}
+
+// partnerContactText returns how the user can be reached, username first and then mobile number
+func partnerContactText(user *User) string {
+	if user.Username != "" {
+		return fmt.Sprintf("username: @%s", user.Username)
+	}
+	if user.MobileNumber != "" && user.MobileNumber != "empty" {
+		return fmt.Sprintf("Mobile Number: %s", user.MobileNumber)
+	}
+	return "No public contact, use ✉️ Send a message."
+}
+
+// handleLikePartner likes the partner of a card and notifies both users when they like each other
+func handleLikePartner(bot *tgbotapi.BotAPI, callback *tgbotapi.CallbackQuery, user *User, partnerID int64) bool {
+	// check user limit for likes per day
+	if status, ok := consumeQuota(user, QuotaActionLike); !ok {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallbackWithAlert(callback.ID, quotaExceededText(user, status)))
+		return false
+	}
+
+	if !likePartner(user.TelegramID, partnerID) {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "👍 Liked! You will be notified if it's a match."))
+		return true
+	}
+
+	var partner User
+	if err := db.Where("telegram_id = ?", partnerID).First(&partner).Error; err != nil {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "This partner is not available anymore."))
+		return true
+	}
+
+	connectPartners(partnerID, user.TelegramID)
+	bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "🎉 It's a match!"))
+	sendMatchMessage(bot, user, &partner)
+	sendMatchMessage(bot, &partner, user)
+	return true
+}
+
+// sendMatchMessage tells the user about the match with the partner and how to reach them
+func sendMatchMessage(bot *tgbotapi.BotAPI, user *User, partner *User) {
+	text := fmt.Sprintf("🎉 It's a match! You and %s liked each other.\nEnglish Level: %s\n%s",
+		partner.Name, partner.EnglishLevel, partnerContactText(partner))
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✉️ Send a message", fmt.Sprintf("match:msg:%d", partner.TelegramID)),
+		),
+	)
+	if _, err := sendUserCard(bot, user.TelegramID, partner, text, keyboard); err != nil {
+		log.Println("Error sending match message:", err)
+	}
+}
+
+// isConnected checks if the two users have an accepted follow request in any direction
+func isConnected(userID, partnerID int64) bool {
+	var count int
+	db.Model(&FollowRequest{}).
+		Where("accepted = ? AND ((requester_id = ? AND target_id = ?) OR (requester_id = ? AND target_id = ?))",
+			true, userID, partnerID, partnerID, userID).Count(&count)
+	return count > 0
+}
+
+// getConnectedPartnerIDs returns the telegram IDs of the users with an accepted follow request with the user
+func getConnectedPartnerIDs(telegramID int64) []int64 {
+	var followRequests []FollowRequest
+	if err := db.Where("accepted = ? AND (requester_id = ? OR target_id = ?)", true, telegramID, telegramID).
+		Order("updated_at desc").Find(&followRequests).Error; err != nil {
+		log.Println("Error querying database for connected partners:", err)
+		return nil
+	}
+
+	partnerIDs := make([]int64, 0, len(followRequests))
+	for _, followRequest := range followRequests {
+		if followRequest.RequesterID == telegramID {
+			partnerIDs = append(partnerIDs, followRequest.TargetID)
+		} else {
+			partnerIDs = append(partnerIDs, followRequest.RequesterID)
+		}
+	}
+	return partnerIDs
+}
+
+// handleMatchCallback handles the send a message button of a match, the next text of the user is relayed
+func handleMatchCallback(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	callback := update.CallbackQuery
+	chatID := callback.Message.Chat.ID
+
+	partnerID, err := strconv.ParseInt(strings.TrimPrefix(callback.Data, "match:msg:"), 10, 64)
+	if err != nil || !isConnected(chatID, partnerID) {
+		log.Println("Invalid match callback data:", callback.Data)
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+
+	db.Model(&User{}).Where("telegram_id = ?", chatID).Update("pending_relay_to", partnerID)
+	bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+	sendMessage(bot, chatID, "✉️ Type your message, it will be sent to your partner by the bot.", backToHomeMenuKeyboard)
+}
+
+// relayMatchMessage sends the text of the user to the partner waiting in PendingRelayTo
+func relayMatchMessage(bot *tgbotapi.BotAPI, update tgbotapi.Update, user *User) {
+	partnerID := user.PendingRelayTo
+	db.Model(user).Update("pending_relay_to", 0)
+
+	if !isConnected(user.TelegramID, partnerID) {
+		sendMessage(bot, update.Message.Chat.ID, "You are not connected to this partner anymore.", mainKeyboard)
+		return
+	}
+	if update.Message.Text == "" {
+		sendMessage(bot, update.Message.Chat.ID, "Only text messages can be sent.", mainKeyboard)
+		return
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("↩️ Reply", fmt.Sprintf("match:msg:%d", user.TelegramID)),
+		),
+	)
+	sendMessage(bot, partnerID, fmt.Sprintf("✉️ Message from %s:\n\n%s", user.Name, update.Message.Text), keyboard)
+	sendMessage(bot, update.Message.Chat.ID, "Your message has been sent!", mainKeyboard)
+	recordActivity(bot, user.TelegramID, ActivityMessage)
+}
+
+// handleSwipeModeCommand switches partner cards between follow requests and silent likes
+func handleSwipeModeCommand(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	user.SwipeMode = !user.SwipeMode
+	db.Model(user).Update("swipe_mode", user.SwipeMode)
+
+	if user.SwipeMode {
+		sendMessage(bot, chatID, "👍 Swipe mode is on: like partners silently, you are matched when you like each other.", mainKeyboard)
+	} else {
+		sendMessage(bot, chatID, "✅ Swipe mode is off: send follow requests to partners.", mainKeyboard)
+	}
+}
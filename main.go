@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -30,19 +29,45 @@ type User struct {
 	Username                   string `gorm:"unique"`
 	Name                       string
 	MobileNumber               string
-	EnglishLevel               string
+	EnglishLevel               LanguageLevel
 	Gender                     string
 	MediaID                    uint
 	Latitude                   float64
 	Longitude                  float64
-	CurrentQuestion            int       // Added field to track the current question
-	CurrentFindPartnerQuestion int       // Added field to track the current find partner feature question
-	LastSelectedEnglishLevel   string    // Added filed for store last selected english level filter
-	LastSelectedGender         string    // Added filed for store last selected gender filter
-	LastFindPartnerTime        time.Time // Added field to store last time find partner
-	CountWatchPartnerLimit     int       // Added for store count of watch user partner from limited partners list in each day (24 hours)
-	CurrentNumberInPartnerList int       // Added for store current number of partners search in json data
-	CurrentEditProfileQuestion string    // Added for store current user edit profile question as string
+	CurrentQuestion            int           // Added field to track the current question
+	CurrentFindPartnerQuestion int           // Added field to track the current find partner feature question
+	LastSelectedEnglishLevel   string        // Added filed for store last selected level range filter of the selected language, e.g. "B1–B2"
+	LastSelectedLanguage       string        // Added for store last selected language filter, ISO 639-1 code
+	LastSelectedExchange       bool          // Added for store if the last search was a language exchange
+	LastSelectedGender         string        // Added filed for store last selected gender filter
+	CurrentEditProfileQuestion string        // Added for store current user edit profile question as string
+	Timezone                   string        // Added for store user IANA timezone name, used for daily limits
+	ViewDigest                 bool          // Added for store if user wants the daily profile views digest
+	LastViewDigestAt           time.Time     // Added for store last time the profile views digest was sent
+	BrowseInvisibly            bool          // Added for store if user views of partners are hidden from them
+	SwipeMode                  bool          // Added for store if user likes partners silently instead of follow requests
+	PendingRelayTo             int64         // Added for store matched partner ID the next user message is relayed to
+	PendingPracticeWith        int64         // Added for store partner ID the next user message proposes practice slots to
+	Availability               int64         // Added for store weekly availability grid as bit mask of local time blocks
+	Interests                  string        // Added for store comma separated interests, used to pick conversation topics
+	PlacementLevel             LanguageLevel // Added for store CEFR level of the last placement test
+	PlacementTestedAt          *time.Time    // Added for store date of the last placement test, nil if never tested
+	Reputation                 float64       // Added for store Bayesian average of the partner ratings
+	RatingCount                int           // Added for store number of partner ratings counted in the reputation
+	StreakDays                 int           // Added for store number of consecutive local days with practice activity
+	LongestStreak              int           // Added for store longest practice streak in days
+	LastActiveOn               string        // Added for store last local day with practice activity as YYYY-MM-DD
+	Leaderboard                bool          // Added for store if user joined the weekly leaderboard
+	LastVocabReviewAt          time.Time     // Added for store last time the daily vocabulary review reminder was sent
+	ReferralCode               string        `gorm:"index"`        // Added for store code of the user's invite link, created on first use
+	ProfileSlug                *string       `gorm:"unique_index"` // Added for store public handle of the user's profile link, NULL until first used
+	PendingProfileSlug         string        // Added for store profile handle opened by a new user, shown after the registration
+	HiddenFromSearch           bool          // Added for store if user is hidden from find partner results
+	PausedUntil                *time.Time    // Added for store end of the pause, a paused user is not shown to anyone
+	VerifiedOnly               bool          // Added for store if user is only shown to verified users
+	HidePhoto                  bool          // Added for store if user profile photo is hidden until a follow request is accepted
+	DeletionScheduledAt        *time.Time    // Added for store time the account is deleted, nil if no deletion is requested
+	LastDataExportAt           time.Time     // Added for store last time the user exported their data
 	// Add the following relationship for follow requests
 	FollowRequestsSent     []FollowRequest `gorm:"foreignkey:RequesterID"`
 	FollowRequestsReceived []FollowRequest `gorm:"foreignkey:TargetID"`
@@ -57,15 +82,19 @@ type Media struct {
 
 type FollowRequest struct {
 	gorm.Model
-	RequesterID int64 // ID of the user sending the follow request
-	TargetID    int64 // ID of the user being followed
-	Accepted    bool  // Indicates whether the follow request is accepted
+	RequesterID int64      // ID of the user sending the follow request
+	TargetID    int64      // ID of the user being followed
+	Accepted    bool       // Indicates whether the follow request is accepted
+	RemindedAt  *time.Time // Time the reminder was sent to the target
+	ExpiredAt   *time.Time // Time the pending follow request expired
 }
 
 type WatchList struct {
-	ID      uint
-	UserID  int64 // ID of the user watched
-	WatchID int64 // ID of the user seen by above user_id
+	ID        uint
+	UserID    int64 // ID of the user watched
+	WatchID   int64 // ID of the user seen by above user_id
+	Invisible bool  // view is hidden from the seen user (invisible browsing)
+	CreatedAt time.Time
 }
 
 var db *gorm.DB
@@ -86,26 +115,30 @@ const (
 const (
 	UsersToShowLimit = 10
 	WaitTimeLimit    = 24 * time.Hour
+	// candidates loaded before ranking by common availability
+	MatchingPartnersCandidates = 5 * UsersToShowLimit
 )
 
 var mainKeyboard = tgbotapi.NewReplyKeyboard(
 	tgbotapi.NewKeyboardButtonRow(
 		tgbotapi.NewKeyboardButton("🤜🤛👥 Find Partner"),
+		tgbotapi.NewKeyboardButton("👀 Who viewed me"),
+		tgbotapi.NewKeyboardButton("⚡ Practice now"),
 	),
 	tgbotapi.NewKeyboardButtonRow(
 		tgbotapi.NewKeyboardButton("🧑‍💼 Show Profile"),
 		tgbotapi.NewKeyboardButton("🧑‍💼🛠️ Edit Profile"),
+		tgbotapi.NewKeyboardButton("⭐ Rate partner"),
 	),
-)
-
-var englishLevelKeyboard = tgbotapi.NewReplyKeyboard(
 	tgbotapi.NewKeyboardButtonRow(
-		tgbotapi.NewKeyboardButton("Beginner"),
-		tgbotapi.NewKeyboardButton("Intermediate"),
-		tgbotapi.NewKeyboardButton("Advanced"),
+		tgbotapi.NewKeyboardButton("📅 Schedule practice"),
+		tgbotapi.NewKeyboardButton("💡 Topic"),
+		tgbotapi.NewKeyboardButton("👥 Group practice"),
 	),
 )
 
+var englishLevelKeyboard = tgbotapi.NewReplyKeyboard(levelButtonRows()...)
+
 var selectGenderKeyboard = tgbotapi.NewReplyKeyboard(
 	tgbotapi.NewKeyboardButtonRow(
 		tgbotapi.NewKeyboardButton("👨 Male"),
@@ -121,13 +154,9 @@ var selectGenderFilterKeyboard = tgbotapi.NewReplyKeyboard(
 	),
 )
 
-var selectNextOrAcceptPartnerKeyboard = tgbotapi.NewReplyKeyboard(
+var skipProfilePhotoKeyboard = tgbotapi.NewReplyKeyboard(
 	tgbotapi.NewKeyboardButtonRow(
-		tgbotapi.NewKeyboardButton("✅ Follow Partner"),
-		tgbotapi.NewKeyboardButton("➡️ Next Partner"),
-	),
-	tgbotapi.NewKeyboardButtonRow(
-		tgbotapi.NewKeyboardButton("🏠 Back To Home Menu"),
+		tgbotapi.NewKeyboardButton("⏭️ Skip profile photo"),
 	),
 )
 
@@ -150,17 +179,22 @@ var editProfileMenuKeyboard = tgbotapi.NewReplyKeyboard(
 		tgbotapi.NewKeyboardButton("👫 Edit Gender"),
 		tgbotapi.NewKeyboardButton("🖼️ Edit Profile Photo"),
 	),
+	tgbotapi.NewKeyboardButtonRow(
+		tgbotapi.NewKeyboardButton("🌍 Edit Timezone"),
+		tgbotapi.NewKeyboardButton("🕒 Edit Availability"),
+		tgbotapi.NewKeyboardButton("🎯 Edit Interests"),
+	),
+	tgbotapi.NewKeyboardButtonRow(
+		tgbotapi.NewKeyboardButton("🗣️ Edit Languages"),
+		tgbotapi.NewKeyboardButton("📝 Placement test"),
+		tgbotapi.NewKeyboardButton("⚙️ Visibility"),
+	),
 	tgbotapi.NewKeyboardButtonRow(
 		tgbotapi.NewKeyboardButton("🏠 Back To Home Menu"),
 	),
 )
 
-var editEnglishLevelKeyboard = tgbotapi.NewReplyKeyboard(
-	tgbotapi.NewKeyboardButtonRow(
-		tgbotapi.NewKeyboardButton("Beginner"),
-		tgbotapi.NewKeyboardButton("Intermediate"),
-		tgbotapi.NewKeyboardButton("Advanced"),
-	),
+var editEnglishLevelKeyboard = tgbotapi.NewReplyKeyboard(append(levelButtonRows(),
 	tgbotapi.NewKeyboardButtonRow(
 		tgbotapi.NewKeyboardButton("👤 Edit Name"),
 		tgbotapi.NewKeyboardButton("🗣️🌍 Edit English Level"),
@@ -170,7 +204,7 @@ var editEnglishLevelKeyboard = tgbotapi.NewReplyKeyboard(
 	tgbotapi.NewKeyboardButtonRow(
 		tgbotapi.NewKeyboardButton("🏠 Back To Home Menu"),
 	),
-)
+)...)
 
 var editGenderKeyboard = tgbotapi.NewReplyKeyboard(
 	tgbotapi.NewKeyboardButtonRow(
@@ -211,12 +245,53 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error connecting to Redis: %v", err)
 	}
+	// Remove profile copies left by the old partner list cache, once
+	purgePartnerCache(context.Background())
+
+	// Profile handles are unique, users without a handle had an empty one before
+	if db.Dialect().HasIndex("users", "idx_users_profile_slug") {
+		db.Model(&User{}).Where("profile_slug = ?", "").Update("profile_slug", gorm.Expr("NULL"))
+		db.Model(&User{}).RemoveIndex("idx_users_profile_slug")
+	}
 
 	// AutoMigrate creates tables based on the User struct
 	db.AutoMigrate(&User{})
 	db.AutoMigrate(&Media{})
 	db.AutoMigrate(&FollowRequest{})
 	db.AutoMigrate(&WatchList{})
+	db.AutoMigrate(&BrowseSession{})
+	db.AutoMigrate(&HiddenPartner{})
+	db.AutoMigrate(&PartnerReport{})
+	db.AutoMigrate(&QuotaUsage{})
+	db.AutoMigrate(&Subscription{})
+	db.AutoMigrate(&PartnerLike{})
+	db.AutoMigrate(&ScheduledJob{})
+	db.AutoMigrate(&PracticeSession{})
+	db.AutoMigrate(&Topic{})
+	db.AutoMigrate(&TopicUsage{})
+	db.AutoMigrate(&PlacementAttempt{})
+	db.AutoMigrate(&UserLanguage{})
+	db.AutoMigrate(&PracticeRoom{})
+	db.AutoMigrate(&RoomGroup{})
+	db.AutoMigrate(&RoomWaiting{})
+	db.AutoMigrate(&PartnerRating{})
+	db.AutoMigrate(&ActivityEvent{})
+	db.AutoMigrate(&UserBadge{})
+	db.AutoMigrate(&LeaderboardEntry{})
+	db.AutoMigrate(&VocabWord{})
+	db.AutoMigrate(&QuotaBonus{})
+	db.AutoMigrate(&Referral{})
+
+	// load the question bank of the placement test
+	loadPlacementQuestions()
+
+	// convert the former Beginner/Intermediate/Advanced levels to CEFR levels
+	migrateEnglishLevels()
+	migrateUserLanguages()
+	migrateTestedBadges()
+
+	// add the topics of the bundled topic bank
+	seedTopics()
 
 	// Replace "YOUR_BOT_TOKEN" with your actual bot token
 	bot, err := tgbotapi.NewBotAPI(apikey)
@@ -224,6 +299,9 @@ func main() {
 		log.Panic(err)
 	}
 
+	// run delayed and recurring jobs (reminders, digests, cleanup) in background
+	startScheduler(bot)
+
 	// Use webhook or long polling based on your deployment environment
 	// For simplicity, we are using long polling here
 	u := tgbotapi.NewUpdate(0)
@@ -250,15 +328,81 @@ func main() {
 
 				// Call the handleAcceptFollow function
 				handleDeclineFollow(bot, update, partnerID)
+			} else if strings.HasPrefix(update.CallbackQuery.Data, "card:") {
+				// Call the handlePartnerCardCallback function
+				handlePartnerCardCallback(bot, update)
+			} else if strings.HasPrefix(update.CallbackQuery.Data, "match:msg:") {
+				// Call the handleMatchCallback function
+				handleMatchCallback(bot, update)
+			} else if strings.HasPrefix(update.CallbackQuery.Data, "practice:") {
+				// Call the handlePracticeCallback function
+				handlePracticeCallback(bot, update)
+			} else if strings.HasPrefix(update.CallbackQuery.Data, "now:") {
+				// Call the handlePracticeNowCallback function
+				handlePracticeNowCallback(bot, update)
+			} else if strings.HasPrefix(update.CallbackQuery.Data, "room:") {
+				// Call the handleRoomCallback function
+				handleRoomCallback(bot, update)
+			} else if strings.HasPrefix(update.CallbackQuery.Data, "lang:") {
+				// Call the handleLanguageCallback function
+				handleLanguageCallback(bot, update)
+			} else if strings.HasPrefix(update.CallbackQuery.Data, "quiz:") {
+				// Call the handlePlacementCallback function
+				handlePlacementCallback(bot, update)
+			} else if strings.HasPrefix(update.CallbackQuery.Data, "topic:") {
+				// Call the handleTopicCallback function
+				handleTopicCallback(bot, update)
+			} else if strings.HasPrefix(update.CallbackQuery.Data, "tz:") {
+				// Call the handleTimezoneCallback function
+				handleTimezoneCallback(bot, update)
+			} else if strings.HasPrefix(update.CallbackQuery.Data, "avail:") {
+				// Call the handleAvailabilityCallback function
+				handleAvailabilityCallback(bot, update)
+			} else if strings.HasPrefix(update.CallbackQuery.Data, "rate:") {
+				// Call the handleRatingCallback function
+				handleRatingCallback(bot, update)
+			} else if strings.HasPrefix(update.CallbackQuery.Data, "lb:") {
+				// Call the handleLeaderboardCallback function
+				handleLeaderboardCallback(bot, update)
+			} else if strings.HasPrefix(update.CallbackQuery.Data, "vocab:") {
+				// Call the handleVocabCallback function
+				handleVocabCallback(bot, update)
+			} else if strings.HasPrefix(update.CallbackQuery.Data, "vis:") {
+				// Call the handleVisibilityCallback function
+				handleVisibilityCallback(bot, update)
+			} else if strings.HasPrefix(update.CallbackQuery.Data, "account:") {
+				// Call the handleAccountCallback function
+				handleAccountCallback(bot, update)
+			} else if strings.HasPrefix(update.CallbackQuery.Data, "viewers:") {
+				// Call the handleViewersCallback function
+				handleViewersCallback(bot, update)
 			}
 		}
 
+		// Telegram asks to confirm an invoice before charging the user
+		if update.PreCheckoutQuery != nil {
+			handlePreCheckoutQuery(bot, update.PreCheckoutQuery)
+			continue
+		}
+
 		if update.Message == nil {
 			continue
 		}
 
-		// Check if the user has started the bot
-		if update.Message.Text == "/start" {
+		// Group chats are practice rooms, they are not users
+		if !update.Message.Chat.IsPrivate() {
+			handleGroupMessage(bot, update.Message)
+			continue
+		}
+
+		// Premium payment is done
+		if update.Message.SuccessfulPayment != nil {
+			handleSuccessfulPayment(bot, update.Message)
+			continue
+		}
+
+		// Check if the user has started the bot, with or without a deep link payload
+		if update.Message.Command() == "start" {
 			startBot(bot, update)
 			continue
 		}
@@ -278,7 +422,7 @@ func handleAcceptFollow(bot *tgbotapi.BotAPI, update tgbotapi.Update, partnerID
 
 	// Update the follow request status in the database
 	result := db.Model(&FollowRequest{}).
-		Where("requester_id = ? AND target_id = ? AND accepted = ?", partnerID, existUser.TelegramID, false).
+		Where("requester_id = ? AND target_id = ? AND accepted = ? AND expired_at IS NULL", partnerID, existUser.TelegramID, false).
 		Update("accepted", true)
 	if result.RowsAffected == 0 {
 		sendMessage(bot, existUser.TelegramID, "No follow request found to Accept.", backToHomeMenuKeyboard)
@@ -311,6 +455,9 @@ func handleAcceptFollow(bot *tgbotapi.BotAPI, update tgbotapi.Update, partnerID
 		messageText2 = fmt.Sprintf("You have accepted the follow request \n Mobile Number: %s: ", existUser2.MobileNumber)
 	}
 	sendMessage(bot, existUser.TelegramID, messageText2, backToHomeMenuKeyboard)
+
+	// Ask both partners to rate each other once they had time to practice
+	scheduleRatingPrompts(existUser.TelegramID, partnerID)
 }
 
 // Add the following function to handle declining a follow request
@@ -322,7 +469,7 @@ func handleDeclineFollow(bot *tgbotapi.BotAPI, update tgbotapi.Update, partnerID
 	}
 
 	// Delete the follow request from the database
-	result := db.Where("requester_id = ? AND target_id = ? AND accepted = ?", partnerID, existUser.TelegramID, false).Delete(&FollowRequest{})
+	result := db.Where("requester_id = ? AND target_id = ? AND accepted = ? AND expired_at IS NULL", partnerID, existUser.TelegramID, false).Delete(&FollowRequest{})
 	if result.RowsAffected == 0 {
 		sendMessage(bot, existUser.TelegramID, "No follow request found to delete.", backToHomeMenuKeyboard)
 		return
@@ -339,10 +486,14 @@ func handleDeclineFollow(bot *tgbotapi.BotAPI, update tgbotapi.Update, partnerID
 func startBot(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
 	// Check if the user already exists in the database
 	userID := int(update.Message.Chat.ID)
+	payload := strings.TrimSpace(update.Message.CommandArguments())
 	var existingUser User
 	if err := db.Where("telegram_id = ? AND name IS NOT NULL AND mobile_number IS NOT NULL AND name != '' AND mobile_number != ''", userID).First(&existingUser).Error; err == nil {
 		// User already exists, display a message or a button
 		sendExistingUserMessage(bot, update.Message.Chat.ID, &existingUser)
+		if payload != "" {
+			handleStartPayload(bot, &existingUser, payload, false)
+		}
 		return
 	}
 
@@ -354,6 +505,9 @@ func startBot(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
 	db.FirstOrCreate(&user, User{TelegramID: int64(update.Message.Chat.ID)})
 	user.CurrentQuestion = QuestionName // Start with the first question
 	db.Save(&user)
+	if payload != "" {
+		handleStartPayload(bot, &user, payload, true)
+	}
 
 	bot.Send(msg)
 	askQuestion(bot, update.Message.Chat.ID, &user)
@@ -402,8 +556,8 @@ func askQuestion(bot *tgbotapi.BotAPI, chatID int64, user *User) {
 }
 
 func sendPhotoQuestion(bot *tgbotapi.BotAPI, chatID int64) {
-	msg := tgbotapi.NewMessage(chatID, "Upload your profile photo.")
-	msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+	msg := tgbotapi.NewMessage(chatID, "Upload your profile photo (optional).")
+	msg.ReplyMarkup = skipProfilePhotoKeyboard
 	bot.Send(msg)
 }
 
@@ -426,6 +580,32 @@ func handleUserResponse(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
 	var user User
 	db.FirstOrCreate(&user, User{TelegramID: int64(update.Message.Chat.ID)})
 
+	// Process admin commands with arguments
+	switch update.Message.Command() {
+	case "refund":
+		handleRefundCommand(bot, update.Message.Chat.ID, &user, update.Message.CommandArguments())
+		return
+	case "addtopic":
+		handleAddTopicCommand(bot, update.Message.Chat.ID, &user, update.Message.CommandArguments())
+		return
+	case "profilelink":
+		handleProfileLinkCommand(bot, update.Message.Chat.ID, &user, update.Message.CommandArguments())
+		return
+	case "word":
+		handleWordCommand(bot, update.Message.Chat.ID, &user, update.Message.CommandArguments())
+		return
+	case "review":
+		handleReviewCommand(bot, update.Message.Chat.ID, &user)
+		return
+	case "exportwords":
+		handleExportWordsCommand(bot, update.Message.Chat.ID, &user, update.Message.CommandArguments())
+		return
+	}
+	if update.Message.Document != nil && strings.HasPrefix(update.Message.Caption, "/importtopics") {
+		handleImportTopicsDocument(bot, update.Message, &user)
+		return
+	}
+
 	// Process the user's response
 	switch update.Message.Text {
 	case "Next Question":
@@ -447,21 +627,81 @@ func handleUserResponse(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
 	case "🖼️ Edit Profile Photo":
 		setCurrentEditProfileQuestion(&user, "profile_photo")
 		sendMessage(bot, update.Message.Chat.ID, "Please Upload Your Profile Photo:", editProfileMenuKeyboard)
+	case "🌍 Edit Timezone":
+		handleEditTimezone(bot, update.Message.Chat.ID, &user)
+	case "🕒 Edit Availability":
+		handleEditAvailability(bot, update.Message.Chat.ID, &user)
+	case "⚙️ Visibility", "/visibility":
+		handleVisibilitySettings(bot, update.Message.Chat.ID, &user)
 	case "🤜🤛👥 Find Partner":
 		// Start the process of finding a partner
 		handleFindPartner(bot, update.Message.Chat.ID, &user)
-	case "➡️ Next Partner":
-		// Start the process of Next Partner
-		handleNextPartner(bot, update.Message.Chat.ID, &user)
-	case "✅ Follow Partner":
-		// Start the process of Follow Partner
-		handleFollowRequest(bot, update, &user)
+	case "⚡ Practice now":
+		// Enter the live queue for an instant anonymous practice chat
+		handlePracticeNow(bot, update.Message.Chat.ID, &user)
+	case "👥 Group practice":
+		// Join the waiting pool of a group practice room
+		handleGroupPractice(bot, update.Message.Chat.ID, &user)
+	case "💡 Topic":
+		// Suggest a conversation topic for an accepted partner
+		handleTopicCommand(bot, update.Message.Chat.ID, &user)
+	case "📝 Placement test", "/placement":
+		// Start the placement test, the result sets the English level
+		handlePlacementCommand(bot, update.Message.Chat.ID, &user)
+	case "🗣️ Edit Languages":
+		handleEditLanguages(bot, update.Message.Chat.ID, &user)
+	case "🎯 Edit Interests":
+		handleEditInterests(bot, update.Message.Chat.ID, &user)
+	case "📅 Schedule practice":
+		// Propose a practice session to an accepted partner
+		handleSchedulePractice(bot, update.Message.Chat.ID, &user)
+	case "👀 Who viewed me":
+		// Show recent profile viewers
+		handleWhoViewedMe(bot, update.Message.Chat.ID, &user)
+	case "⭐ Rate partner", "/rate":
+		// Rate a connected partner
+		handleRateCommand(bot, update.Message.Chat.ID, &user)
+	case "/deleteaccount":
+		// Delete the account after a grace period
+		handleDeleteAccountCommand(bot, update.Message.Chat.ID, &user)
+	case "/exportdata":
+		// Send everything stored about the user
+		handleExportDataCommand(bot, update.Message.Chat.ID, &user)
+	case "/invite":
+		// Show the invite link and referral rewards
+		handleInviteCommand(bot, update.Message.Chat.ID, &user)
+	case "/referrals":
+		// Show the referral funnel, admins only
+		handleReferralsCommand(bot, update.Message.Chat.ID, &user)
+	case "/leaderboard":
+		// Show the weekly leaderboard
+		handleLeaderboardCommand(bot, update.Message.Chat.ID, &user)
+	case "/limits":
+		// Show remaining daily limits
+		handleLimitsCommand(bot, update.Message.Chat.ID, &user)
+	case "/swipemode":
+		// Switch between follow requests and likes
+		handleSwipeModeCommand(bot, update.Message.Chat.ID, &user)
+	case "/premium":
+		// Show premium plans
+		handlePremiumCommand(bot, update.Message.Chat.ID, &user)
 	case "🏠 Back To Home Menu":
-		// Go To Home Menu
+		// Go To Home Menu, cancel a pending message to a match or a practice proposal
+		if user.PendingRelayTo != 0 || user.PendingPracticeWith != 0 {
+			db.Model(&user).Updates(map[string]interface{}{"pending_relay_to": 0, "pending_practice_with": 0})
+		}
 		startBot(bot, update)
 	default:
 		// Process responses to filter questions during finding a partner
-		if user.CurrentFindPartnerQuestion == 1000 {
+		if session := getPracticeNowSession(user.TelegramID); session != nil {
+			relayPracticeNowMessage(bot, update, session)
+		} else if user.PendingRelayTo != 0 {
+			relayMatchMessage(bot, update, &user)
+		} else if user.PendingPracticeWith != 0 {
+			processPracticeSlots(bot, update, &user)
+		} else if user.CurrentFindPartnerQuestion == 1003 {
+			handleLanguageFilter(bot, update, &user)
+		} else if user.CurrentFindPartnerQuestion == 1000 {
 			handleEnglishLevelFilter(bot, update, &user)
 		} else if user.CurrentFindPartnerQuestion == 1001 {
 			handleGenderFilter(bot, update, &user)
@@ -472,19 +712,14 @@ func handleUserResponse(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
 	}
 }
 
-// handle follow request function
-func handleFollowRequest(bot *tgbotapi.BotAPI, update tgbotapi.Update, user *User) {
-	partners, err := getPartnersFromCache(user.TelegramID)
-	if err != nil {
-		// Handle the error
-		log.Println("Error retrieving partners from cache for handle follow request function:", err)
-		return
-	}
-	// Get the user ID of the partner to follow
-	partnerID := partners[user.CurrentNumberInPartnerList].TelegramID
-
+// sendFollowRequest creates a follow request from the user to the partner and notifies the partner,
+// returns false if a follow request already exists
+func sendFollowRequest(bot *tgbotapi.BotAPI, user *User, partnerID int64) bool {
 	// Check if a follow request already exists
 	if !isFollowRequestExists(user.TelegramID, partnerID) {
+		// Remove an expired follow request whose retry cooldown is over
+		db.Where("requester_id = ? AND target_id = ? AND accepted = ? AND expired_at IS NOT NULL", user.TelegramID, partnerID, false).Delete(&FollowRequest{})
+
 		// Create a new follow request
 		followRequest := FollowRequest{
 			RequesterID: user.TelegramID,
@@ -495,96 +730,53 @@ func handleFollowRequest(bot *tgbotapi.BotAPI, update tgbotapi.Update, user *Use
 
 		// Send a follow request message to the partner
 		sendFollowRequestMessage(bot, partnerID, user, user.TelegramID)
-
-		// Notify the user that the follow request is sent
-		sendMessage(bot, update.Message.Chat.ID, "Your follow request has been sent!", backToHomeMenuKeyboard)
-	} else {
-		// Notify the user that a follow request already exists
-		sendMessage(bot, update.Message.Chat.ID, "You have already sent a follow request to this partner.", backToHomeMenuKeyboard)
+		return true
 	}
+
+	// a follow request already exists
+	return false
 }
 
 func sendFollowRequestMessage(bot *tgbotapi.BotAPI, partnerID int64, user *User, requesterID int64) {
 	// Customize the follow request message
 	messageText := fmt.Sprintf("%s is requesting to follow you. ✅ Accept or ❌ Decline? \nEnglish Level: %s\n", user.Name, user.EnglishLevel)
 
-	// Create a keyboard with accept and decline buttons
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+	// send requester card, with or without profile photo, a hidden photo is not shown before the request is accepted
+	target := User{TelegramID: partnerID}
+	if _, err := sendUserCard(bot, partnerID, cardUserFor(user, &target), messageText, followRequestKeyboard(requesterID)); err != nil {
+		log.Println("Error sending follow request message:", err)
+	}
+}
+
+// followRequestKeyboard creates a keyboard with accept and decline buttons
+func followRequestKeyboard(requesterID int64) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("✅ Accept", fmt.Sprintf("accept_follow:%d", requesterID)),
 			tgbotapi.NewInlineKeyboardButtonData("❌ Decline", fmt.Sprintf("decline_follow:%d", requesterID)),
 		),
 	)
-
-	if user.MediaID != 0 {
-		// Get the media record
-		var media Media
-		if err := db.First(&media, user.MediaID).Error; err != nil {
-			log.Println("Error getting media record for follow requester user:", err)
-			return
-		}
-
-		// send Profile Detail With Caption
-		photo := tgbotapi.NewPhotoUpload(partnerID, media.Filename)
-		photo.Caption = messageText
-		photo.ReplyMarkup = keyboard
-		bot.Send(photo)
-	}
 }
 
-// Add the following function to check if a follow request already exists
+// Add the following function to check if a follow request already exists,
+// expired follow requests count until their retry cooldown is over
 func isFollowRequestExists(requesterID, targetID int64) bool {
-	var count int
-	db.Model(&FollowRequest{}).Where("requester_id = ? AND target_id = ?", requesterID, targetID).Count(&count)
-	return count > 0
-}
-
-// handle Next Partner Function
-func handleNextPartner(bot *tgbotapi.BotAPI, chatID int64, user *User) {
-	var currentPositionInCache int
-	if user.CurrentNumberInPartnerList > 0 {
-		currentPositionInCache = user.CurrentNumberInPartnerList + 1
-	} else {
-		currentPositionInCache = 1
-	}
-	partners, err := getPartnersFromCache(user.TelegramID)
-	if err != nil {
-		// Handle the error
-		log.Println("Error retrieving partners from cache:", err)
-		return
-	}
-
-	// Use the partners data as needed
-	partnersLength := len(partners)
-	if partnersLength > currentPositionInCache {
-		showPartnerDetail(bot, chatID, user, partners, currentPositionInCache)
-		user.CurrentNumberInPartnerList = currentPositionInCache
-		db.Save(user)
-	} else {
-		sendErrorMessage(bot, chatID, "dont exist another partner for you.")
-	}
+	return followRequestBlockedText(requesterID, targetID) != ""
 }
 
 // showUserDetails displays user details, including the image, for existing users
 func showUserDetails(bot *tgbotapi.BotAPI, chatID int64, user *User) {
 	// Customize this message based on the details you want to show
-	profileDetailsText := fmt.Sprintf("🧑‍💼 User Profile Details:\nName: %s\nMobile Number: %s\nEnglish Level: %s\nGender: %s",
-		user.Name, user.MobileNumber, user.EnglishLevel, user.Gender)
-
-	// Check if the user has a profile photo
-	if user.MediaID != 0 {
-		// Get the media record
-		var media Media
-		if err := db.First(&media, user.MediaID).Error; err != nil {
-			log.Println("Error getting media record:", err)
-			return
-		}
-
-		// send Profile Detail With Caption
-		photo := tgbotapi.NewPhotoUpload(chatID, media.Filename)
-		photo.Caption = profileDetailsText
-		bot.Send(photo)
+	profileDetailsText := fmt.Sprintf("🧑‍💼 User Profile Details:\nName: %s\nMobile Number: %s\n%s\nGender: %s\nTimezone: %s\nAvailability: %s",
+		user.Name, user.MobileNumber, languagesText(user.TelegramID), user.Gender, userLocation(user).String(), availabilityText(user))
+	if badge := placementBadge(user); badge != "" {
+		profileDetailsText += "\n" + badge
 	}
+	profileDetailsText += "\n" + reputationText(user)
+	profileDetailsText += "\n" + achievementsText(user)
+
+	// send Profile Detail, with or without profile photo
+	sendUserCard(bot, chatID, user, profileDetailsText, mainKeyboard)
 }
 
 // handle edit profile, for existing users
@@ -600,9 +792,14 @@ func handleEditProfileName(bot *tgbotapi.BotAPI, update tgbotapi.Update, user *U
 }
 
 func handleEditProfileEnglishLevel(bot *tgbotapi.BotAPI, update tgbotapi.Update, user *User) {
-	if update.Message.Text == "Beginner" || update.Message.Text == "Intermediate" || update.Message.Text == "Advanced" {
-		user.EnglishLevel = update.Message.Text
+	if level, ok := parseLevel(update.Message.Text); ok {
+		user.EnglishLevel = level
+		// a self-declared level different from the tested one loses the tested badge
+		if user.PlacementTestedAt != nil && user.PlacementLevel != user.EnglishLevel {
+			user.PlacementTestedAt = nil
+		}
 		db.Save(user)
+		syncEnglishLevel(user.TelegramID, user.EnglishLevel)
 		setCurrentEditProfileQuestion(user, "empty")
 		sendMessage(bot, update.Message.Chat.ID, "Your English Level has been edited successfully", editProfileMenuKeyboard)
 	} else {
@@ -736,10 +933,10 @@ func processUserAnswer(bot *tgbotapi.BotAPI, update tgbotapi.Update, user *User)
 			} // End if
 		} // End if
 	case QuestionEnglishLevel:
-		if isValidEnglishLevel(update.Message.Text) {
-			user.EnglishLevel = update.Message.Text
+		if level, ok := parseLevel(update.Message.Text); ok {
+			user.EnglishLevel = level
 		} else {
-			sendErrorMessage(bot, update.Message.Chat.ID, "Invalid English level. Please select from Beginner, Intermediate, or Advanced.")
+			sendErrorMessage(bot, update.Message.Chat.ID, "Invalid English level. "+levelHelpText())
 			return
 		}
 	case QuestionProfilePhoto:
@@ -749,9 +946,12 @@ func processUserAnswer(bot *tgbotapi.BotAPI, update tgbotapi.Update, user *User)
 			handleExistingUser(bot, user)
 			mediaID := handlePhotoUpload(bot, update.Message)
 			user.MediaID = mediaID
+		} else if update.Message.Text == "⏭️ Skip profile photo" {
+			// Profile photo is optional, partner cards are shown as text only
+			user.MediaID = 0
 		} else {
 			// Handle the case where the user did not upload a photo
-			sendErrorMessage(bot, update.Message.Chat.ID, "Please Upload a Your Profile Photo.")
+			sendErrorMessage(bot, update.Message.Chat.ID, "Please upload your profile photo or skip this step.")
 			return
 		}
 	case QuestionGender:
@@ -780,6 +980,8 @@ func processUserAnswer(bot *tgbotapi.BotAPI, update tgbotapi.Update, user *User)
 				handleEditProfileEnglishLevel(bot, update, user)
 			case "gender":
 				handleEditProfileGender(bot, update, user)
+			case "timezone":
+				handleEditProfileTimezone(bot, update, user)
 			case "profile_photo":
 				// Check if the user uploaded a photo
 				if update.Message.Photo != nil && len(*update.Message.Photo) > 0 {
@@ -884,10 +1086,20 @@ func processUserAnswers(bot *tgbotapi.BotAPI, chatID int64, user *User) {
 		sendErrorMessage(bot, chatID, "Failed to store user data. Please try again.")
 		return
 	}
+	syncEnglishLevel(user.TelegramID, user.EnglishLevel)
 
 	// Show a success message to the user
 	successMessage := "Thank you for completing the registration! You are now a registered user."
 	sendReplyBackMessageFeatures(bot, int64(user.TelegramID), user, successMessage)
+
+	// Reward the friend who invited the user
+	completeReferral(bot, user)
+
+	// Show the profile the new user came for
+	if slug := user.PendingProfileSlug; slug != "" {
+		db.Model(user).Update("pending_profile_slug", "")
+		openProfileLink(bot, user, slug)
+	}
 }
 
 // isValidMobileNumber checks if the provided string is a valid 11-digit mobile number
@@ -915,16 +1127,6 @@ func isNumeric(str string) bool {
 	return true
 }
 
-// isValidEnglishLevel checks if the provided string is a valid English level
-func isValidEnglishLevel(englishLevel string) bool {
-	// Implement your English level validation logic here
-	if englishLevel == "Beginner" || englishLevel == "Intermediate" || englishLevel == "Advanced" {
-		return englishLevel != ""
-	} else {
-		return false
-	}
-}
-
 // sendErrorMessage sends an error message to the user
 func sendErrorMessage(bot *tgbotapi.BotAPI, chatID int64, message string) {
 	msg := tgbotapi.NewMessage(chatID, message)
@@ -934,9 +1136,14 @@ func sendErrorMessage(bot *tgbotapi.BotAPI, chatID int64, message string) {
 // *** Find Partner Functions ***
 // handleFindPartner initiates the process of finding a partner
 func handleFindPartner(bot *tgbotapi.BotAPI, chatID int64, user *User) {
-	// Ask the first filter question (English level)
-	setCurrentFindPartnerQuestion(user)
-	sendMessage(bot, chatID, "What's the preferred English level of your potential partner?", englishLevelKeyboard)
+	// Ask the first filter question (language), skipped when the user only learns one language
+	options := languageFilterOptions(user.TelegramID)
+	if len(options) == 1 {
+		applyLanguageFilter(bot, chatID, user, options[0])
+		return
+	}
+	setCurrentFindPartnerQuestion(user, 1003)
+	sendMessage(bot, chatID, "Which language do you want to practice?", languageFilterKeyboard(options))
 }
 
 func setCurrentFindPartnerQuestion(user *User, number ...int) {
@@ -959,16 +1166,17 @@ func setCurrentEditProfileQuestion(user *User, question string) {
 
 // handleEnglishLevelFilter processes the user's English level filter response
 func handleEnglishLevelFilter(bot *tgbotapi.BotAPI, update tgbotapi.Update, user *User) {
-	switch update.Message.Text {
-	case "Beginner", "Intermediate", "Advanced":
-		user.CurrentFindPartnerQuestion = 1001
-		user.LastSelectedEnglishLevel = update.Message.Text
-		db.Save(user)
-		// Ask the next filter question (gender)
-		sendMessage(bot, update.Message.Chat.ID, "What's the preferred gender of your potential partner?", selectGenderFilterKeyboard)
-	default:
-		sendErrorMessage(bot, update.Message.Chat.ID, "Invalid English level option. Please select from Beginner, Intermediate, or Advanced.")
+	min, max, ok := parseLevelRange(update.Message.Text)
+	if !ok {
+		sendErrorMessage(bot, update.Message.Chat.ID, "Invalid English level option. "+levelHelpText()+" Or type a range like B1–B2.")
+		return
 	}
+
+	user.CurrentFindPartnerQuestion = 1001
+	user.LastSelectedEnglishLevel = levelRangeText(min, max)
+	db.Save(user)
+	// Ask the next filter question (gender)
+	sendMessage(bot, update.Message.Chat.ID, "What's the preferred gender of your potential partner?", selectGenderFilterKeyboard)
 }
 
 // handleGenderFilter processes the user's gender filter response
@@ -992,23 +1200,29 @@ func handleGenderFilter(bot *tgbotapi.BotAPI, update tgbotapi.Update, user *User
 
 // processFindPartnerAnswers processes the user's answers after all questions are answered in the context of finding a partner
 func processFindPartnerAnswers(bot *tgbotapi.BotAPI, chatID int64, user *User) {
-	// Get partners based on filters (English level and gender)
-	partners := getMatchingPartners(user.LastSelectedEnglishLevel, user.LastSelectedGender, user.TelegramID)
-
-	// Cache partners in Redis
-	cachePartners(partners, user.TelegramID)
+	// check user limit for new searches per day
+	if status, ok := consumeQuota(user, QuotaActionReroll); !ok {
+		sendMessage(bot, chatID, quotaExceededText(user, status), mainKeyboard)
+		setCurrentFindPartnerQuestion(user, 1002)
+		return
+	}
 
-	// Display the first partner to the user
-	if len(partners) > 0 {
+	// Get partners based on filters (language, level and gender)
+	partners := getMatchingPartners(partnerFilter{
+		Language:   user.LastSelectedLanguage,
+		Exchange:   user.LastSelectedExchange,
+		LevelRange: user.LastSelectedEnglishLevel,
+		Gender:     user.LastSelectedGender,
+	}, user)
 
-		// Update LastFindPartnerTime to the current timestamp
-		if has24HoursPassed(user.LastFindPartnerTime) == true {
-			user.LastFindPartnerTime = time.Now()
-			user.CountWatchPartnerLimit = 0
-			db.Save(user)
-		}
+	// Store partners in a new browsing session
+	session := startBrowseSession(user, partners)
 
-		showPartnerDetail(bot, chatID, user, partners, 0)
+	// Display the first partner to the user
+	if len(partners) > 0 && session != nil {
+		// cards are browsed with inline buttons, keep only the home button in the reply keyboard
+		sendMessage(bot, chatID, "Use the buttons under each partner card to ✅ Follow or watch ➡️ Next Partner.", backToHomeMenuKeyboard)
+		showPartnerDetail(bot, chatID, user, session, nil)
 	} else {
 		// Inform the user that no matching partners were found
 		sendMessage(bot, chatID, "No matching partners found. Try adjusting your preferences.", mainKeyboard)
@@ -1018,133 +1232,134 @@ func processFindPartnerAnswers(bot *tgbotapi.BotAPI, chatID int64, user *User) {
 	setCurrentFindPartnerQuestion(user, 1002)
 }
 
-// has24HoursPassed checks if 24 hours have passed since the stored time
-func has24HoursPassed(lastTime time.Time) bool {
-	// Calculate the time difference
-	timeDifference := time.Since(lastTime)
-	// Calculate the difference between the current time and the stored time
-	hoursPassed := int(timeDifference.Hours())
-
-	return hoursPassed >= 24
-}
-
-// Show Partner Detail To user
-func showPartnerDetail(bot *tgbotapi.BotAPI, chatID int64, user *User, partners []*User, partnerKeyToShow int) {
-	// check user time & count limit for watch partner per day
-	if has24HoursPassed(user.LastFindPartnerTime) == false && user.CountWatchPartnerLimit >= 20 {
-		sendMessage(bot, chatID, "🔒⏰ You need to wait 24 hours before finding the next partner.", backToHomeMenuKeyboard)
-		return
+// Show Partner Detail To user, shows the partner under the session cursor.
+// When cardMessage is set, the card is edited in place instead of sending a new message.
+func showPartnerDetail(bot *tgbotapi.BotAPI, chatID int64, user *User, session *BrowseSession, cardMessage *tgbotapi.Message) {
+	// Load the partner from database, so profile changes are reflected.
+	// Partners deleted or hidden since the search are skipped.
+	var partner User
+	for {
+		partner = User{}
+		err := db.Where("telegram_id = ?", session.currentPartnerID()).First(&partner).Error
+		if err == nil && visibilityBlockedText(user, &partner) == "" {
+			break
+		}
+		if err != nil && !gorm.IsRecordNotFoundError(err) {
+			log.Println("Error getting partner for browse session:", err)
+		}
+		if !session.setCursor(session.Cursor + 1) {
+			if cardMessage != nil {
+				bot.DeleteMessage(tgbotapi.NewDeleteMessage(chatID, cardMessage.MessageID))
+			}
+			sendMessage(bot, chatID, "dont exist another partner for you.", backToHomeMenuKeyboard)
+			return
+		}
 	}
+	partnerID := partner.TelegramID
+	seen := session.hasSeen(partnerID)
 
 	// Customize this message based on the details you want to show
-	partnerDetailsText := fmt.Sprintf("👥 Partner Details:\nName: %s\nEnglish Level: %s\n",
-		partners[partnerKeyToShow].Name, partners[partnerKeyToShow].EnglishLevel)
-
-	// set current number in partner list to 0
-	user.CurrentNumberInPartnerList = 0
-
-	// Add Count watch partner limit
-	user.CountWatchPartnerLimit = user.CountWatchPartnerLimit + 1
-	db.Save(user)
-
-	// Add Seen User ID in WatchList model
-	newWatch := WatchList{
-		UserID:  chatID,
-		WatchID: partners[partnerKeyToShow].TelegramID,
+	partnerDetailsText := fmt.Sprintf("👥 Partner Details (%d/%d):\nName: %s\n%s\n",
+		session.Cursor+1, len(session.partners()), partner.Name, languagesText(partner.TelegramID))
+	if badge := placementBadge(&partner); badge != "" {
+		partnerDetailsText += badge + "\n"
+	}
+	partnerDetailsText += reputationText(&partner) + "\n"
+	if hours := commonAvailabilityHours(user, &partner); hours > 0 {
+		partnerDetailsText += fmt.Sprintf("🕒 Common free time: %d hours/week\n", hours)
+	}
+	if !photoVisibleTo(&partner, user) {
+		partnerDetailsText += "🖼️ Photo is shown after your follow request is accepted\n"
 	}
-	db.Create(&newWatch)
 
-	// Check if the user has a profile photo
-	if partners[partnerKeyToShow].MediaID != 0 {
-		// Get the media record
-		var media Media
-		if err := db.First(&media, partners[partnerKeyToShow].MediaID).Error; err != nil {
-			log.Println("Error getting partner media record:", err)
+	if !seen {
+		// check user limit for watch partner per day, partners already seen in this session are free
+		if status, ok := consumeQuota(user, QuotaActionView); !ok {
+			sendMessage(bot, chatID, quotaExceededText(user, status), backToHomeMenuKeyboard)
 			return
 		}
 
-		// send Profile Detail With Caption
-		photo := tgbotapi.NewPhotoUpload(chatID, media.Filename)
-		photo.Caption = partnerDetailsText
-		bot.Send(photo)
-	} else {
-		// Show partner details to the user
-		sendMessage(bot, chatID, partnerDetailsText, selectNextOrAcceptPartnerKeyboard)
-	}
-	// show Accept or Next Menu to user
-	sendMessage(bot, chatID, "Please ✅ Follow or Watch ➡️ Next Partner...", selectNextOrAcceptPartnerKeyboard)
-}
+		// Add Seen User ID in WatchList model
+		newWatch := WatchList{
+			UserID:    chatID,
+			WatchID:   partnerID,
+			Invisible: user.BrowseInvisibly,
+		}
+		db.Create(&newWatch)
 
-// cachePartners caches the list of partners in Redis
-func cachePartners(partners []*User, telegramID int64) {
-	// Convert partners to JSON
-	partnersJSON, err := json.Marshal(partners)
-	if err != nil {
-		log.Println("Error marshaling partners to JSON:", err)
-		return
+		session.markSeen(partnerID)
+		db.Save(session)
 	}
 
-	telegramIDStr := strconv.FormatInt(telegramID, 10)
-	redisKey := "partners:" + telegramIDStr
+	// card buttons are bound to this partner and session
+	keyboard := partnerCardKeyboard(user, session, partnerID)
 
-	// Store partners in Redis with an expiration time (e.g., 24 hours)
-	err = redisClient.Set(context.Background(), redisKey, partnersJSON, 12*time.Hour).Err()
-	if err != nil {
-		log.Println("Error caching partners in Redis:", err)
+	// Show partner details to the user, with or without profile photo
+	if cardMessage != nil {
+		if err := editUserCard(bot, cardMessage, cardUserFor(&partner, user), partnerDetailsText, keyboard); err != nil {
+			log.Println("Error editing partner card:", err)
+		}
+		return
 	}
+	sendUserCard(bot, chatID, cardUserFor(&partner, user), partnerDetailsText, keyboard)
 }
 
-// getPartnersFromCache retrieves partner data from the Redis cache based on telegramID and keyNumber
-func getPartnersFromCache(telegramID int64) ([]*User, error) {
-	telegramIDStr := strconv.FormatInt(telegramID, 10)
-	redisKey := fmt.Sprintf("partners:%s", telegramIDStr)
-
-	partnersJSON, err := redisClient.Get(context.Background(), redisKey).Result()
-	if err != nil {
-		log.Println("Error getting partners from Redis:", err)
-		return nil, err
-	}
-
-	// Unmarshal the JSON data into []*User
-	var partners []*User
-	if err := json.Unmarshal([]byte(partnersJSON), &partners); err != nil {
-		log.Println("Error unmarshaling partners from JSON:", err)
-		return nil, err
-	}
-
-	return partners, nil
+// partnerFilter holds the find partner filters
+type partnerFilter struct {
+	Language   string // ISO 639-1 code, English if empty
+	Exchange   bool   // natives of the language learning a native language of the user, the level range is ignored
+	LevelRange string // e.g. "B1–B2", the level of the partner in the language
+	Gender     string
 }
 
-// getMatchingPartners retrieves partners from the database based on English level and gender filters
-func getMatchingPartners(englishLevel, gender string, telegramID int64) []*User {
+// getMatchingPartners retrieves partners from the database based on language, level range and gender filters,
+// ranked by the hours of availability they share with the user
+func getMatchingPartners(filter partnerFilter, user *User) []*User {
 	// Implement your logic to query the database for matching partners
 	var matchingPartners []*User
 
-	// Get the watch IDs for the given user
-	watchIDs := getWatchIDs(telegramID)
+	// Get the watch IDs and the skipped forever IDs for the given user
+	watchIDs := append(getWatchIDs(user.TelegramID), getHiddenIDs(user.TelegramID)...)
 
-	if gender == "no matter" {
-		query := db.Where("english_level = ? AND telegram_id != ?", englishLevel, telegramID).Limit(10)
-
-		if len(watchIDs) > 0 {
-			query = query.Not("telegram_id IN (?)", watchIDs)
-		}
+	language := filter.Language
+	if language == "" {
+		language = EnglishLanguage
+	}
 
-		if err := query.Find(&matchingPartners).Error; err != nil {
-			log.Println("Error querying database for matching partners:", err)
+	// more candidates than shown are loaded, so the best availability matches come first
+	query := db.Where("telegram_id != ?", user.TelegramID).Limit(MatchingPartnersCandidates)
+	query = visibleToQuery(query, user)
+	if filter.Exchange {
+		// language exchange: my target is your native language and your target is one of my native languages
+		natives, _ := getUserLanguages(user.TelegramID)
+		nativeCodes := make([]string, 0, len(natives))
+		for _, native := range natives {
+			nativeCodes = append(nativeCodes, native.Language)
 		}
+		query = query.
+			Where("telegram_id IN (SELECT user_id FROM user_languages WHERE language = ? AND native = ?)", language, true).
+			Where("telegram_id IN (SELECT user_id FROM user_languages WHERE language IN (?) AND native = ?)", nativeCodes, false)
 	} else {
-		query := db.Where("english_level = ? AND gender = ? AND telegram_id != ?", englishLevel, gender, telegramID).Limit(10)
+		min, max, _ := parseLevelRange(filter.LevelRange)
+		query = query.Where("telegram_id IN (SELECT user_id FROM user_languages WHERE language = ? AND native = ? AND level IN (?))",
+			language, false, levelsBetween(min, max))
+	}
+	if filter.Gender != "no matter" {
+		query = query.Where("gender = ?", filter.Gender)
+	}
 
-		if len(watchIDs) > 0 {
-			query = query.Not("telegram_id IN (?)", watchIDs)
-		}
+	if len(watchIDs) > 0 {
+		query = query.Not("telegram_id IN (?)", watchIDs)
+	}
 
-		if err := query.Find(&matchingPartners).Error; err != nil {
-			log.Println("Error querying database for matching partners:", err)
-		}
+	if err := query.Find(&matchingPartners).Error; err != nil {
+		log.Println("Error querying database for matching partners:", err)
 	}
 
+	matchingPartners = rankPartners(user, matchingPartners)
+	if len(matchingPartners) > UsersToShowLimit {
+		matchingPartners = matchingPartners[:UsersToShowLimit]
+	}
 	return matchingPartners
 }
 
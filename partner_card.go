@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// HiddenPartner stores partners the user skipped forever, they are never matched again
+type HiddenPartner struct {
+	ID        uint  `gorm:"primary_key"`
+	UserID    int64 `gorm:"index"` // ID of the user who skipped
+	HiddenID  int64 // ID of the skipped partner
+	CreatedAt time.Time
+}
+
+// PartnerReport stores a report of a partner card sent by a user
+type PartnerReport struct {
+	ID         uint  `gorm:"primary_key"`
+	ReporterID int64 `gorm:"index"` // ID of the reporting user
+	ReportedID int64 `gorm:"index"` // ID of the reported partner
+	CreatedAt  time.Time
+}
+
+// partner card callback actions, callback data format is card:<action>:<session key>:<partner ID>
+const (
+	CardActionFollow   = "follow"
+	CardActionLike     = "like"
+	CardActionNext     = "next"
+	CardActionPrevious = "prev"
+	CardActionSkip     = "skip"
+	CardActionReport   = "report"
+	CardActionInfo     = "info"
+)
+
+// partnerCardCallbackData builds the callback data of a partner card button
+func partnerCardCallbackData(action string, session *BrowseSession, partnerID int64) string {
+	return fmt.Sprintf("card:%s:%s:%d", action, session.SessionKey, partnerID)
+}
+
+// partnerCardKeyboard builds the inline actions of a partner card, in swipe mode Follow is replaced by Like
+func partnerCardKeyboard(user *User, session *BrowseSession, partnerID int64) tgbotapi.InlineKeyboardMarkup {
+	connect := tgbotapi.NewInlineKeyboardButtonData("✅ Follow", partnerCardCallbackData(CardActionFollow, session, partnerID))
+	if user.SwipeMode {
+		connect = tgbotapi.NewInlineKeyboardButtonData("👍 Like", partnerCardCallbackData(CardActionLike, session, partnerID))
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			connect,
+			tgbotapi.NewInlineKeyboardButtonData("ℹ️ More info", partnerCardCallbackData(CardActionInfo, session, partnerID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⬅️ Previous", partnerCardCallbackData(CardActionPrevious, session, partnerID)),
+			tgbotapi.NewInlineKeyboardButtonData("➡️ Next", partnerCardCallbackData(CardActionNext, session, partnerID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🚫 Skip forever", partnerCardCallbackData(CardActionSkip, session, partnerID)),
+			tgbotapi.NewInlineKeyboardButtonData("⚠️ Report", partnerCardCallbackData(CardActionReport, session, partnerID)),
+		),
+	)
+}
+
+// parsePartnerCardCallback parses card:<action>:<session key>:<partner ID> callback data
+func parsePartnerCardCallback(data string) (action, sessionKey string, partnerID int64, ok bool) {
+	parts := strings.Split(strings.TrimPrefix(data, "card:"), ":")
+	if len(parts) != 3 {
+		return "", "", 0, false
+	}
+	partnerID, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return "", "", 0, false
+	}
+	return parts[0], parts[1], partnerID, true
+}
+
+// handlePartnerCardCallback handles the inline buttons of a partner card
+func handlePartnerCardCallback(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	callback := update.CallbackQuery
+	action, sessionKey, partnerID, ok := parsePartnerCardCallback(callback.Data)
+	if !ok {
+		log.Println("Invalid partner card callback data:", callback.Data)
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+
+	chatID := callback.Message.Chat.ID
+	var user User
+	if err := db.Where("telegram_id = ?", chatID).First(&user).Error; err != nil {
+		log.Printf("Error getting user %d for partner card callback: %v", chatID, err)
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+
+	// the partner must belong to one of the user's browsing sessions
+	session := getBrowseSessionByKey(user.TelegramID, sessionKey)
+	if session == nil || !session.hasPartner(partnerID) {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallbackWithAlert(callback.ID, "Your partner search has expired. Please start 🤜🤛👥 Find Partner again."))
+		return
+	}
+
+	// the partner may have paused, limited the profile or scheduled the deletion since the card was shown
+	if action == CardActionFollow || action == CardActionLike || action == CardActionInfo {
+		if blocked := partnerUnavailableText(&user, partnerID); blocked != "" {
+			bot.AnswerCallbackQuery(tgbotapi.NewCallbackWithAlert(callback.ID, blocked))
+			return
+		}
+	}
+
+	switch action {
+	case CardActionFollow:
+		if blocked := followRequestBlockedText(user.TelegramID, partnerID); blocked != "" {
+			bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, blocked))
+			return
+		}
+		// check user limit for follow requests per day
+		if status, ok := consumeQuota(&user, QuotaActionFollow); !ok {
+			bot.AnswerCallbackQuery(tgbotapi.NewCallbackWithAlert(callback.ID, quotaExceededText(&user, status)))
+			return
+		}
+		sendFollowRequest(bot, &user, partnerID)
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "Your follow request has been sent!"))
+	case CardActionLike:
+		// swipe to the next partner once the like is recorded
+		if handleLikePartner(bot, callback, &user, partnerID) {
+			showNextPartnerAfter(bot, chatID, &user, session, partnerID, callback.Message)
+		}
+	case CardActionInfo:
+		bot.AnswerCallbackQuery(tgbotapi.NewCallbackWithAlert(callback.ID, partnerMoreInfoText(partnerID)))
+	case CardActionNext, CardActionPrevious:
+		step := 1
+		if action == CardActionPrevious {
+			step = -1
+		}
+		// move relative to the partner of this card, not to whatever the cursor points at
+		if !session.setCursor(session.indexOf(partnerID) + step) {
+			if step > 0 {
+				bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "dont exist another partner for you."))
+			} else {
+				bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "This is the first partner of your search."))
+			}
+			return
+		}
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		showPartnerDetail(bot, chatID, &user, session, callback.Message)
+	case CardActionSkip:
+		hidePartner(user.TelegramID, partnerID)
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "🚫 This partner will not be shown to you again."))
+		showNextPartnerAfter(bot, chatID, &user, session, partnerID, callback.Message)
+	case CardActionReport:
+		reportPartner(user.TelegramID, partnerID)
+		hidePartner(user.TelegramID, partnerID)
+		bot.AnswerCallbackQuery(tgbotapi.NewCallbackWithAlert(callback.ID, "⚠️ Thank you, the partner has been reported and will not be shown to you again."))
+		showNextPartnerAfter(bot, chatID, &user, session, partnerID, callback.Message)
+	default:
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+	}
+}
+
+// showNextPartnerAfter replaces the card of the partner with the next partner of the session, or removes the card
+func showNextPartnerAfter(bot *tgbotapi.BotAPI, chatID int64, user *User, session *BrowseSession, partnerID int64, cardMessage *tgbotapi.Message) {
+	if session.setCursor(session.indexOf(partnerID) + 1) {
+		showPartnerDetail(bot, chatID, user, session, cardMessage)
+		return
+	}
+
+	bot.DeleteMessage(tgbotapi.NewDeleteMessage(chatID, cardMessage.MessageID))
+	sendMessage(bot, chatID, "dont exist another partner for you.", backToHomeMenuKeyboard)
+}
+
+// partnerUnavailableText returns why the viewer can not act on the partner anymore, or "" if allowed
+func partnerUnavailableText(viewer *User, partnerID int64) string {
+	var partner User
+	if err := db.Where("telegram_id = ?", partnerID).First(&partner).Error; err != nil {
+		return "This partner is not available anymore."
+	}
+	return visibilityBlockedText(viewer, &partner)
+}
+
+// partnerMoreInfoText returns the extra details of a partner shown by the More info button
+func partnerMoreInfoText(partnerID int64) string {
+	var partner User
+	if err := db.Where("telegram_id = ?", partnerID).First(&partner).Error; err != nil {
+		return "This partner is not available anymore."
+	}
+
+	return fmt.Sprintf("👥 %s\n%s\n%s\nGender: %s\nMember since: %s",
+		partner.Name, languagesText(partner.TelegramID), reputationText(&partner), partner.Gender, partner.CreatedAt.Format("2006-01-02"))
+}
+
+// hidePartner stores the partner as skipped forever by the user
+func hidePartner(userID, partnerID int64) {
+	hidden := HiddenPartner{UserID: userID, HiddenID: partnerID}
+	if err := db.Where(hidden).FirstOrCreate(&hidden).Error; err != nil {
+		log.Println("Error hiding partner:", err)
+	}
+}
+
+// reportPartner stores a report of the partner by the user
+func reportPartner(reporterID, partnerID int64) {
+	report := PartnerReport{ReporterID: reporterID, ReportedID: partnerID}
+	if err := db.Create(&report).Error; err != nil {
+		log.Println("Error creating partner report:", err)
+	}
+}
+
+// getHiddenIDs retrieves the IDs of the partners skipped forever by the user
+func getHiddenIDs(telegramID int64) []int64 {
+	var hiddenIDs []int64
+
+	if err := db.Model(&HiddenPartner{}).Where("user_id = ?", telegramID).Pluck("hidden_id", &hiddenIDs).Error; err != nil {
+		log.Println("Error querying database for hidden IDs:", err)
+	}
+
+	return hiddenIDs
+}
+
+// getBlockedIDs retrieves the IDs of the users the user must not be paired with:
+// users hidden or reported by the user, and users who hid or reported the user
+func getBlockedIDs(telegramID int64) []int64 {
+	var blockedIDs []int64
+
+	err := db.Raw(`SELECT hidden_id AS id FROM hidden_partners WHERE user_id = ?
+		UNION SELECT user_id FROM hidden_partners WHERE hidden_id = ?
+		UNION SELECT reported_id FROM partner_reports WHERE reporter_id = ?
+		UNION SELECT reporter_id FROM partner_reports WHERE reported_id = ?`,
+		telegramID, telegramID, telegramID, telegramID).Pluck("id", &blockedIDs).Error
+	if err != nil {
+		log.Println("Error querying database for blocked IDs:", err)
+	}
+
+	return blockedIDs
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/jinzhu/gorm"
+)
+
+// StarsCurrency is the currency code of Telegram Stars, invoices in stars need no payment provider
+const StarsCurrency = "XTR"
+
+// premiumPlan describes a premium subscription that can be bought with stars
+type premiumPlan struct {
+	ID    string
+	Title string
+	Days  int
+	Stars int
+}
+
+// premiumPlans lists the premium plans in display order
+var premiumPlans = []premiumPlan{
+	{ID: "month", Title: "💎 Premium - 1 month", Days: 30, Stars: 250},
+	{ID: "quarter", Title: "💎 Premium - 3 months", Days: 90, Stars: 600},
+}
+
+// Subscription stores a paid premium period of a user
+type Subscription struct {
+	gorm.Model
+	UserID     int64  `gorm:"index"` // telegram ID of the subscriber
+	Plan       string // premium plan ID
+	Stars      int    // amount paid in stars
+	ChargeID   string `gorm:"unique_index"` // telegram payment charge ID, needed for refunds
+	StartsAt   time.Time
+	ExpiresAt  time.Time
+	RefundedAt *time.Time
+}
+
+// getPremiumPlan returns the plan with the given ID
+func getPremiumPlan(planID string) (premiumPlan, bool) {
+	for _, plan := range premiumPlans {
+		if plan.ID == planID {
+			return plan, true
+		}
+	}
+	return premiumPlan{}, false
+}
+
+// getPremiumExpiry returns the end of the premium of the user including the chained later periods,
+// zero if the user has no period running now
+func getPremiumExpiry(telegramID int64) time.Time {
+	var active int
+	now := time.Now()
+	db.Model(&Subscription{}).Where("user_id = ? AND refunded_at IS NULL AND starts_at <= ? AND expires_at > ?", telegramID, now, now).Count(&active)
+	if active == 0 {
+		return time.Time{}
+	}
+	return premiumPaidUntil(telegramID)
+}
+
+// premiumPaidUntil returns the end of the last paid period of the user, a new period is chained to it
+func premiumPaidUntil(telegramID int64) time.Time {
+	var subscription Subscription
+	if err := db.Where("user_id = ? AND refunded_at IS NULL AND expires_at > ?", telegramID, time.Now()).
+		Order("expires_at desc").First(&subscription).Error; err != nil {
+		return time.Time{}
+	}
+	return subscription.ExpiresAt
+}
+
+// isPremium checks if the user has an active premium subscription, other features use it to gate premium options
+func isPremium(telegramID int64) bool {
+	return !getPremiumExpiry(telegramID).IsZero()
+}
+
+// requirePremium checks if the user is premium (or admin) and shows the premium offer otherwise
+func requirePremium(bot *tgbotapi.BotAPI, chatID int64, user *User, feature string) bool {
+	if isAdmin(user.TelegramID) || isPremium(user.TelegramID) {
+		return true
+	}
+
+	sendMessage(bot, chatID, fmt.Sprintf("💎 %s is a premium feature. Send /premium to upgrade.", feature), mainKeyboard)
+	return false
+}
+
+// handlePremiumCommand shows the premium benefits and sends an invoice for every plan
+func handlePremiumCommand(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	text := "💎 Premium benefits:\n" +
+		fmt.Sprintf("👀 %d partner views per day instead of %d\n", quotaRules[QuotaActionView].Limits[TierPremium], quotaRules[QuotaActionView].Limits[TierFree]) +
+		fmt.Sprintf("✅ %d follow requests per day instead of %d\n", quotaRules[QuotaActionFollow].Limits[TierPremium], quotaRules[QuotaActionFollow].Limits[TierFree]) +
+		"🕵️ See who viewed your profile"
+	if expiry := getPremiumExpiry(user.TelegramID); !expiry.IsZero() {
+		text += fmt.Sprintf("\n\nYour premium is active until %s. Buying again extends it.", expiry.In(userLocation(user)).Format("2006-01-02 15:04"))
+	}
+	sendMessage(bot, chatID, text, mainKeyboard)
+
+	for _, plan := range premiumPlans {
+		prices := []tgbotapi.LabeledPrice{{Label: plan.Title, Amount: plan.Stars}}
+		invoice := tgbotapi.NewInvoice(chatID, plan.Title,
+			fmt.Sprintf("%d days of premium in English Partner Go Bot", plan.Days),
+			premiumInvoicePayload(plan, user.TelegramID), "", "premium", StarsCurrency, &prices)
+		if _, err := bot.Send(invoice); err != nil {
+			log.Println("Error sending premium invoice:", err)
+		}
+	}
+}
+
+// premiumInvoicePayload builds the invoice payload, format is premium:<plan ID>:<telegram ID>
+func premiumInvoicePayload(plan premiumPlan, telegramID int64) string {
+	return fmt.Sprintf("premium:%s:%d", plan.ID, telegramID)
+}
+
+// parsePremiumInvoicePayload parses the invoice payload and checks that it belongs to the paying user
+func parsePremiumInvoicePayload(payload string, telegramID int64) (premiumPlan, bool) {
+	parts := strings.Split(payload, ":")
+	if len(parts) != 3 || parts[0] != "premium" {
+		return premiumPlan{}, false
+	}
+	if payerID, err := strconv.ParseInt(parts[2], 10, 64); err != nil || payerID != telegramID {
+		return premiumPlan{}, false
+	}
+	return getPremiumPlan(parts[1])
+}
+
+// handlePreCheckoutQuery validates an invoice before telegram charges the user
+func handlePreCheckoutQuery(bot *tgbotapi.BotAPI, query *tgbotapi.PreCheckoutQuery) {
+	answer := tgbotapi.PreCheckoutConfig{PreCheckoutQueryID: query.ID, OK: true}
+
+	plan, ok := parsePremiumInvoicePayload(query.InvoicePayload, int64(query.From.ID))
+	if !ok || query.Currency != StarsCurrency || query.TotalAmount != plan.Stars {
+		answer.OK = false
+		answer.ErrorMessage = "This invoice is not valid anymore, please send /premium again."
+	}
+
+	if _, err := bot.AnswerPreCheckoutQuery(answer); err != nil {
+		log.Println("Error answering pre checkout query:", err)
+	}
+}
+
+// handleSuccessfulPayment activates or extends the premium subscription after a payment
+func handleSuccessfulPayment(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	payment := message.SuccessfulPayment
+	telegramID := message.Chat.ID
+
+	plan, ok := parsePremiumInvoicePayload(payment.InvoicePayload, telegramID)
+	if !ok {
+		log.Println("Unknown invoice payload in successful payment:", payment.InvoicePayload, payment.TelegramPaymentChargeID)
+		sendMessage(bot, telegramID, "We received your payment but could not match it to a plan. Please contact the support.", mainKeyboard)
+		return
+	}
+
+	// a new period starts when the current one ends
+	startsAt := time.Now()
+	if expiry := premiumPaidUntil(telegramID); expiry.After(startsAt) {
+		startsAt = expiry
+	}
+
+	subscription := Subscription{
+		UserID:    telegramID,
+		Plan:      plan.ID,
+		Stars:     payment.TotalAmount,
+		ChargeID:  payment.TelegramPaymentChargeID,
+		StartsAt:  startsAt,
+		ExpiresAt: startsAt.AddDate(0, 0, plan.Days),
+	}
+	if err := db.Create(&subscription).Error; err != nil {
+		log.Println("Error creating subscription:", err, payment.TelegramPaymentChargeID)
+		sendMessage(bot, telegramID, "We received your payment but could not activate premium. Please contact the support.", mainKeyboard)
+		return
+	}
+
+	var user User
+	db.Where("telegram_id = ?", telegramID).First(&user)
+	sendMessage(bot, telegramID, fmt.Sprintf("🎉 Thank you! Your premium is active until %s.",
+		subscription.ExpiresAt.In(userLocation(&user)).Format("2006-01-02 15:04")), mainKeyboard)
+}
+
+// handleRefundCommand refunds a premium payment, usage: /refund <telegram payment charge ID> (admins only)
+func handleRefundCommand(bot *tgbotapi.BotAPI, chatID int64, user *User, args string) {
+	if !isAdmin(user.TelegramID) {
+		return
+	}
+
+	chargeID := strings.TrimSpace(args)
+	if chargeID == "" {
+		sendMessage(bot, chatID, "Usage: /refund <telegram payment charge ID>", mainKeyboard)
+		return
+	}
+
+	var subscription Subscription
+	if err := db.Where("charge_id = ?", chargeID).First(&subscription).Error; err != nil {
+		sendMessage(bot, chatID, "No subscription found for this charge ID.", mainKeyboard)
+		return
+	}
+	if subscription.RefundedAt != nil {
+		sendMessage(bot, chatID, "This payment is already refunded.", mainKeyboard)
+		return
+	}
+
+	params := url.Values{}
+	params.Add("user_id", strconv.FormatInt(subscription.UserID, 10))
+	params.Add("telegram_payment_charge_id", subscription.ChargeID)
+	if _, err := bot.MakeRequest("refundStarPayment", params); err != nil {
+		log.Println("Error refunding star payment:", err)
+		sendMessage(bot, chatID, fmt.Sprintf("Refund failed: %v", err), mainKeyboard)
+		return
+	}
+
+	now := time.Now()
+	subscription.RefundedAt = &now
+	db.Save(&subscription)
+
+	// later periods move forward by the unused time of the refunded one, so they do not start after a gap
+	// and a refunded current period does not leave a later period running
+	unusedFrom := subscription.StartsAt
+	if now.After(unusedFrom) {
+		unusedFrom = now
+	}
+	if unused := subscription.ExpiresAt.Sub(unusedFrom); unused > 0 {
+		db.Model(&Subscription{}).Where("user_id = ? AND refunded_at IS NULL AND starts_at >= ?", subscription.UserID, subscription.ExpiresAt).
+			Updates(map[string]interface{}{
+				"starts_at":  gorm.Expr("starts_at - ? * interval '1 second'", unused.Seconds()),
+				"expires_at": gorm.Expr("expires_at - ? * interval '1 second'", unused.Seconds()),
+			})
+	}
+
+	sendMessage(bot, chatID, fmt.Sprintf("Refunded %d ⭐ to user %d.", subscription.Stars, subscription.UserID), mainKeyboard)
+	sendMessage(bot, subscription.UserID, fmt.Sprintf("Your premium payment of %d ⭐ has been refunded.", subscription.Stars), mainKeyboard)
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// bundledPlacementQuestions is the question bank of the placement test, PLACEMENT_QUESTIONS_FILE replaces it
+//
+//go:embed data/placement.json
+var bundledPlacementQuestions []byte
+
+// placement test settings
+const (
+	PlacementQuestionsPerLevel = 3
+	PlacementTimeLimit         = 10 * time.Minute
+	PlacementRetakeCooldown    = 24 * time.Hour
+)
+
+// placementQuestion is a multiple choice question of the question bank
+type placementQuestion struct {
+	Level    LanguageLevel `json:"level"` // CEFR level the question tests
+	Question string        `json:"question"`
+	Options  []string      `json:"options"`
+	Answer   int           `json:"answer"` // index of the correct option
+}
+
+// placementQuestions is the loaded question bank
+var placementQuestions []placementQuestion
+
+// PlacementAttempt is a placement test taken by a user
+type PlacementAttempt struct {
+	ID         uint   `gorm:"primary_key"`
+	UserID     int64  `gorm:"index"`
+	Questions  string // comma separated indexes of the questions in the question bank
+	Current    int    // index of the current question in Questions
+	Correct    int
+	Level      LanguageLevel // CEFR result, set when the test is finished
+	ExpiresAt  time.Time
+	FinishedAt *time.Time
+	CreatedAt  time.Time
+}
+
+// the placement timeout job finishes tests that ran out of time
+func init() {
+	registerJobHandler("placement_timeout", finishExpiredPlacement)
+}
+
+// placementJobPayload is the payload of the placement timeout job
+type placementJobPayload struct {
+	AttemptID uint
+}
+
+// loadPlacementQuestions loads the question bank from PLACEMENT_QUESTIONS_FILE, or the bundled one
+func loadPlacementQuestions() {
+	data := bundledPlacementQuestions
+	if path := os.Getenv("PLACEMENT_QUESTIONS_FILE"); path != "" {
+		fileData, err := os.ReadFile(path)
+		if err != nil {
+			log.Println("Error reading placement questions file, using bundled questions:", err)
+		} else {
+			data = fileData
+		}
+	}
+
+	var questions []placementQuestion
+	if err := json.Unmarshal(data, &questions); err != nil {
+		log.Panicf("invalid placement questions: %v", err)
+	}
+	for i, question := range questions {
+		if !question.Level.Valid() || question.Answer < 0 || question.Answer >= len(question.Options) {
+			log.Panicf("invalid placement question #%d: %q", i, question.Question)
+		}
+	}
+	placementQuestions = questions
+}
+
+// cefrFromScore maps the share of correct answers to a CEFR level, every level is an equal band of the score
+func cefrFromScore(correct, total int) LanguageLevel {
+	if total == 0 {
+		return levelsOrdered[0]
+	}
+	rank := correct * len(levelsOrdered) / (total + 1)
+	return levelsOrdered[rank]
+}
+
+// questionIndexes returns the question bank indexes of the attempt
+func (a *PlacementAttempt) questionIndexes() []int64 {
+	return splitIDs(a.Questions)
+}
+
+// hasValidQuestions checks the questions of the attempt still exist, the question bank may change on restart
+func (a *PlacementAttempt) hasValidQuestions() bool {
+	for _, index := range a.questionIndexes() {
+		if index < 0 || index >= int64(len(placementQuestions)) {
+			return false
+		}
+	}
+	return true
+}
+
+// newPlacementQuestions picks random questions of every level, from the lowest level to the highest
+func newPlacementQuestions() []int64 {
+	var picked []int64
+	for _, level := range levelsOrdered {
+		var indexes []int64
+		for i, question := range placementQuestions {
+			if question.Level == level {
+				indexes = append(indexes, int64(i))
+			}
+		}
+		rand.Shuffle(len(indexes), func(i, j int) { indexes[i], indexes[j] = indexes[j], indexes[i] })
+		if len(indexes) > PlacementQuestionsPerLevel {
+			indexes = indexes[:PlacementQuestionsPerLevel]
+		}
+		picked = append(picked, indexes...)
+	}
+	return picked
+}
+
+// handlePlacementCommand starts a new placement test
+func handlePlacementCommand(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	var last PlacementAttempt
+	if err := db.Where("user_id = ?", user.TelegramID).Order("id desc").First(&last).Error; err == nil {
+		if last.FinishedAt == nil && time.Now().Before(last.ExpiresAt) {
+			sendMessage(bot, chatID, "You already have a placement test in progress, answer the question above.", mainKeyboard)
+			return
+		}
+		if retakeAt := last.CreatedAt.Add(PlacementRetakeCooldown); time.Now().Before(retakeAt) && !isAdmin(user.TelegramID) {
+			sendMessage(bot, chatID, fmt.Sprintf("You can take the placement test again in %s.", formatDuration(time.Until(retakeAt))), mainKeyboard)
+			return
+		}
+	}
+
+	questions := newPlacementQuestions()
+	if len(questions) == 0 {
+		sendErrorMessage(bot, chatID, "The placement test is not available right now.")
+		return
+	}
+
+	attempt := PlacementAttempt{
+		UserID:    user.TelegramID,
+		Questions: joinIDs(questions),
+		ExpiresAt: time.Now().Add(PlacementTimeLimit),
+	}
+	if err := db.Create(&attempt).Error; err != nil {
+		log.Println("Error creating placement attempt:", err)
+		sendErrorMessage(bot, chatID, "Failed to start the placement test. Please try again.")
+		return
+	}
+	if err := scheduleJob("placement_timeout", fmt.Sprintf("placement:%d", attempt.ID), attempt.ExpiresAt, placementJobPayload{AttemptID: attempt.ID}); err != nil {
+		log.Println("Error scheduling placement timeout:", err)
+	}
+
+	sendMessage(bot, chatID, fmt.Sprintf("📝 Placement test: %d questions, you have %s. Choose the correct answer for every question.",
+		len(questions), formatDuration(PlacementTimeLimit)), mainKeyboard)
+
+	question := tgbotapi.NewMessage(chatID, placementQuestionText(&attempt))
+	question.ReplyMarkup = placementQuestionKeyboard(&attempt)
+	bot.Send(question)
+}
+
+// placementQuestionText returns the text of the current question of the attempt
+func placementQuestionText(attempt *PlacementAttempt) string {
+	indexes := attempt.questionIndexes()
+	question := placementQuestions[indexes[attempt.Current]]
+	return fmt.Sprintf("📝 Question %d/%d (⏳ %s left)\n\n%s",
+		attempt.Current+1, len(indexes), formatDuration(time.Until(attempt.ExpiresAt)), question.Question)
+}
+
+// placementQuestionKeyboard builds the options of the current question, the question number makes old buttons invalid
+func placementQuestionKeyboard(attempt *PlacementAttempt) tgbotapi.InlineKeyboardMarkup {
+	question := placementQuestions[attempt.questionIndexes()[attempt.Current]]
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, option := range question.Options {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(option, fmt.Sprintf("quiz:%d:%d:%d", attempt.ID, attempt.Current, i)),
+		))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// handlePlacementCallback handles the answer of a placement test question
+func handlePlacementCallback(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	callback := update.CallbackQuery
+	chatID := callback.Message.Chat.ID
+
+	var values []int
+	for _, part := range strings.Split(strings.TrimPrefix(callback.Data, "quiz:"), ":") {
+		value, err := strconv.Atoi(part)
+		if err != nil {
+			break
+		}
+		values = append(values, value)
+	}
+	if len(values) != 3 {
+		log.Println("Invalid placement callback data:", callback.Data)
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+
+	var attempt PlacementAttempt
+	if err := db.Where("id = ? AND user_id = ?", values[0], chatID).First(&attempt).Error; err != nil {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+	if attempt.FinishedAt != nil {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "This placement test is finished."))
+		removeInlineKeyboard(bot, callback.Message)
+		return
+	}
+	if attempt.Current != values[1] {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "This question is already answered."))
+		return
+	}
+	if time.Now().After(attempt.ExpiresAt) || !attempt.hasValidQuestions() {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "⌛ Time is up!"))
+		finishPlacement(bot, &attempt, callback.Message)
+		return
+	}
+
+	indexes := attempt.questionIndexes()
+	if placementQuestions[indexes[attempt.Current]].Answer == values[2] {
+		attempt.Correct++
+	}
+	attempt.Current++
+
+	// the current question condition makes a double tap count the answer once
+	result := db.Model(&PlacementAttempt{}).Where("id = ? AND current = ? AND finished_at IS NULL", attempt.ID, values[1]).
+		Updates(map[string]interface{}{"current": attempt.Current, "correct": attempt.Correct})
+	if result.Error != nil || result.RowsAffected != 1 {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "This question is already answered."))
+		return
+	}
+	bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+
+	if attempt.Current >= len(indexes) {
+		finishPlacement(bot, &attempt, callback.Message)
+		return
+	}
+
+	edit := tgbotapi.NewEditMessageText(chatID, callback.Message.MessageID, placementQuestionText(&attempt))
+	keyboard := placementQuestionKeyboard(&attempt)
+	edit.ReplyMarkup = &keyboard
+	bot.Send(edit)
+}
+
+// finishExpiredPlacement finishes a placement test whose time limit is over
+func finishExpiredPlacement(bot *tgbotapi.BotAPI, job *ScheduledJob) error {
+	var payload placementJobPayload
+	if err := job.decodePayload(&payload); err != nil {
+		return err
+	}
+
+	var attempt PlacementAttempt
+	if err := db.First(&attempt, payload.AttemptID).Error; err != nil || attempt.FinishedAt != nil {
+		return nil
+	}
+	finishPlacement(bot, &attempt, nil)
+	return nil
+}
+
+// finishPlacement scores the attempt, stores the result on the user and sends it.
+// Unanswered questions count as wrong.
+func finishPlacement(bot *tgbotapi.BotAPI, attempt *PlacementAttempt, questionMessage *tgbotapi.Message) {
+	now := time.Now()
+	total := len(attempt.questionIndexes())
+	attempt.Level = cefrFromScore(attempt.Correct, total)
+	attempt.FinishedAt = &now
+
+	// the last answer and the timeout job can finish the attempt at the same time, only one of them goes on
+	result := db.Model(&PlacementAttempt{}).Where("id = ? AND finished_at IS NULL", attempt.ID).
+		Updates(map[string]interface{}{"level": attempt.Level, "finished_at": &now})
+	if result.Error != nil || result.RowsAffected != 1 {
+		if questionMessage != nil {
+			removeInlineKeyboard(bot, questionMessage)
+		}
+		return
+	}
+	cancelJobs("placement_timeout", fmt.Sprintf("placement:%d", attempt.ID))
+
+	// the tested level replaces the self-declared English level
+	db.Model(&User{}).Where("telegram_id = ?", attempt.UserID).Updates(map[string]interface{}{
+		"placement_level":     attempt.Level,
+		"placement_tested_at": &now,
+		"english_level":       attempt.Level,
+	})
+	syncEnglishLevel(attempt.UserID, attempt.Level)
+
+	if questionMessage != nil {
+		removeInlineKeyboard(bot, questionMessage)
+	}
+	sendMessage(bot, attempt.UserID, fmt.Sprintf("🎓 Placement test finished: %d/%d correct.\nYour level: %s\nYour profile now shows the ✔️ tested badge.",
+		attempt.Correct, total, attempt.Level), mainKeyboard)
+
+	var user User
+	if err := db.Where("telegram_id = ?", attempt.UserID).First(&user).Error; err == nil {
+		awardBadges(bot, &user)
+	}
+}
+
+// placementBadge returns the tested badge of the user, or "" if the user has not taken the placement test
+func placementBadge(user *User) string {
+	if user.PlacementTestedAt == nil {
+		return ""
+	}
+	return fmt.Sprintf("✔️ tested (%s, %s)", string(user.PlacementLevel), user.PlacementTestedAt.Format("2006-01-02"))
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/jinzhu/gorm"
+)
+
+// practice session settings
+const (
+	PracticeSessionDuration = 30 * time.Minute
+	PracticeMaxSlots        = 3
+	PracticeMinLeadTime     = 15 * time.Minute // a slot must start at least this long after the proposal
+	PracticeSlotLayout      = "2006-01-02 15:04"
+)
+
+// practice session statuses
+const (
+	PracticeStatusProposed  = "proposed"
+	PracticeStatusScheduled = "scheduled"
+	PracticeStatusDeclined  = "declined"
+	PracticeStatusDone      = "done"
+)
+
+// PracticeSession is a practice call scheduled between two accepted partners
+type PracticeSession struct {
+	gorm.Model
+	ProposerID        int64      `gorm:"index"` // telegram ID of the partner who proposed the slots
+	PartnerID         int64      `gorm:"index"` // telegram ID of the partner who chooses a slot
+	Slots             string     // comma separated unix times of the proposed slots
+	StartsAt          *time.Time // chosen slot
+	Status            string
+	ProposerConfirmed *bool // proposer's answer after the session: the session took place
+	PartnerConfirmed  *bool // partner's answer after the session: the session took place
+}
+
+// practice reminder and attendance jobs
+func init() {
+	registerJobHandler("practice_reminder", sendPracticeReminder)
+	registerJobHandler("practice_attendance", askPracticeAttendance)
+}
+
+// practiceJobPayload is the payload of the practice session jobs
+type practiceJobPayload struct {
+	SessionID uint
+	Before    int // minutes before the session, reminders only
+}
+
+// slotTimes returns the proposed slots of the session
+func (p *PracticeSession) slotTimes() []time.Time {
+	var slots []time.Time
+	for _, unix := range splitIDs(p.Slots) {
+		slots = append(slots, time.Unix(unix, 0))
+	}
+	return slots
+}
+
+// otherUserID returns the telegram ID of the other side of the session
+func (p *PracticeSession) otherUserID(telegramID int64) int64 {
+	if p.ProposerID == telegramID {
+		return p.PartnerID
+	}
+	return p.ProposerID
+}
+
+// handleSchedulePractice lists the accepted partners of the user to propose a practice session to
+func handleSchedulePractice(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, partnerID := range getConnectedPartnerIDs(user.TelegramID) {
+		var partner User
+		if err := db.Where("telegram_id = ?", partnerID).First(&partner).Error; err != nil {
+			continue
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(partner.Name, fmt.Sprintf("practice:new:%d", partnerID)),
+		))
+	}
+
+	if len(rows) == 0 {
+		sendMessage(bot, chatID, "You have no accepted partners yet. Find a partner and send a follow request first.", mainKeyboard)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, "📅 Who do you want to practice with?")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	bot.Send(msg)
+}
+
+// handlePracticeCallback handles the inline buttons of the practice session flow
+func handlePracticeCallback(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	callback := update.CallbackQuery
+	chatID := callback.Message.Chat.ID
+	parts := strings.Split(strings.TrimPrefix(callback.Data, "practice:"), ":")
+
+	var user User
+	if err := db.Where("telegram_id = ?", chatID).First(&user).Error; err != nil || len(parts) < 2 {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		log.Println("Invalid practice callback data:", callback.Data)
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+
+	switch parts[0] {
+	case "new":
+		if !isConnected(user.TelegramID, id) {
+			bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "You are not connected to this partner anymore."))
+			return
+		}
+		db.Model(&user).Update("pending_practice_with", id)
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		sendMessage(bot, chatID, fmt.Sprintf("📅 Send up to %d time slots, one per line, in your timezone (%s).\nFormat: %s\nExample: %s",
+			PracticeMaxSlots, userLocation(&user).String(), "YYYY-MM-DD HH:MM",
+			time.Now().In(userLocation(&user)).Add(24*time.Hour).Truncate(time.Hour).Format(PracticeSlotLayout)), backToHomeMenuKeyboard)
+	case "accept":
+		if len(parts) != 3 {
+			bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+			return
+		}
+		slotIndex, _ := strconv.Atoi(parts[2])
+		acceptPracticeSlot(bot, callback, &user, uint(id), slotIndex)
+	case "decline":
+		declinePracticeSession(bot, callback, &user, uint(id))
+	case "attended", "missed":
+		confirmPracticeAttendance(bot, callback, &user, uint(id), parts[0] == "attended")
+	default:
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+	}
+}
+
+// processPracticeSlots parses the slots typed by the proposer and sends the proposal to the partner
+func processPracticeSlots(bot *tgbotapi.BotAPI, update tgbotapi.Update, user *User) {
+	chatID := update.Message.Chat.ID
+	location := userLocation(user)
+
+	var slots []int64
+	for _, line := range strings.Split(update.Message.Text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		slot, err := time.ParseInLocation(PracticeSlotLayout, line, location)
+		if err != nil || slot.Before(time.Now().Add(PracticeMinLeadTime)) {
+			sendErrorMessage(bot, chatID, fmt.Sprintf("Invalid time slot %q. Use the format YYYY-MM-DD HH:MM with a time in the future.", line))
+			return
+		}
+		slots = append(slots, slot.Unix())
+	}
+	if len(slots) == 0 || len(slots) > PracticeMaxSlots {
+		sendErrorMessage(bot, chatID, fmt.Sprintf("Please send 1 to %d time slots, one per line.", PracticeMaxSlots))
+		return
+	}
+
+	partnerID := user.PendingPracticeWith
+	db.Model(user).Update("pending_practice_with", 0)
+	if !isConnected(user.TelegramID, partnerID) {
+		sendMessage(bot, chatID, "You are not connected to this partner anymore.", mainKeyboard)
+		return
+	}
+
+	session := PracticeSession{ProposerID: user.TelegramID, PartnerID: partnerID, Slots: joinIDs(slots), Status: PracticeStatusProposed}
+	if err := db.Create(&session).Error; err != nil {
+		log.Println("Error creating practice session:", err)
+		sendErrorMessage(bot, chatID, "Failed to send your proposal. Please try again.")
+		return
+	}
+
+	// slots are shown to the partner in the partner's timezone
+	var partner User
+	db.Where("telegram_id = ?", partnerID).First(&partner)
+	partnerLocation := userLocation(&partner)
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, slot := range session.slotTimes() {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ "+slot.In(partnerLocation).Format(PracticeSlotLayout), fmt.Sprintf("practice:accept:%d:%d", session.ID, i)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("❌ Decline", fmt.Sprintf("practice:decline:%d", session.ID)),
+	))
+
+	sendMessage(bot, partnerID, fmt.Sprintf("📅 %s wants to practice with you. Choose a time (%s):", user.Name, partnerLocation.String()),
+		tgbotapi.NewInlineKeyboardMarkup(rows...))
+	sendMessage(bot, chatID, "Your proposal has been sent! You will be notified when your partner chooses a time.", mainKeyboard)
+}
+
+// acceptPracticeSlot schedules the session at the chosen slot with its reminders and attendance check
+func acceptPracticeSlot(bot *tgbotapi.BotAPI, callback *tgbotapi.CallbackQuery, user *User, sessionID uint, slotIndex int) {
+	var session PracticeSession
+	if err := db.Where("id = ? AND partner_id = ? AND status = ?", sessionID, user.TelegramID, PracticeStatusProposed).First(&session).Error; err != nil {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "This proposal is not available anymore."))
+		return
+	}
+	slots := session.slotTimes()
+	if slotIndex < 0 || slotIndex >= len(slots) || slots[slotIndex].Before(time.Now()) {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "This time is not available anymore."))
+		return
+	}
+
+	// the status condition makes a double tap schedule the session once
+	startsAt := slots[slotIndex]
+	result := db.Model(&PracticeSession{}).Where("id = ? AND status = ?", session.ID, PracticeStatusProposed).
+		Updates(map[string]interface{}{"starts_at": &startsAt, "status": PracticeStatusScheduled})
+	if result.Error != nil || result.RowsAffected != 1 {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "This proposal is not available anymore."))
+		return
+	}
+	session.StartsAt = &startsAt
+	session.Status = PracticeStatusScheduled
+	bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "📅 Scheduled!"))
+	removeInlineKeyboard(bot, callback.Message)
+
+	key := fmt.Sprintf("practice:%d", session.ID)
+	for _, before := range []time.Duration{time.Hour, 10 * time.Minute} {
+		if runAt := startsAt.Add(-before); runAt.After(time.Now()) {
+			if err := scheduleJob("practice_reminder", key, runAt, practiceJobPayload{SessionID: session.ID, Before: int(before.Minutes())}); err != nil {
+				log.Println("Error scheduling practice reminder:", err)
+			}
+		}
+	}
+	if err := scheduleJob("practice_attendance", key, startsAt.Add(PracticeSessionDuration), practiceJobPayload{SessionID: session.ID}); err != nil {
+		log.Println("Error scheduling practice attendance:", err)
+	}
+
+	var proposer User
+	db.Where("telegram_id = ?", session.ProposerID).First(&proposer)
+	sendPracticeConfirmation(bot, &session, &proposer, user)
+	sendPracticeConfirmation(bot, &session, user, &proposer)
+}
+
+// sendPracticeConfirmation sends the scheduled session time in the user's timezone with an .ics calendar file
+func sendPracticeConfirmation(bot *tgbotapi.BotAPI, session *PracticeSession, user *User, partner *User) {
+	location := userLocation(user)
+	sendMessage(bot, user.TelegramID, fmt.Sprintf("📅 Practice session with %s is scheduled at %s (%s). You will be reminded 1 hour and 10 minutes before.",
+		partner.Name, session.StartsAt.In(location).Format(PracticeSlotLayout), location.String()), mainKeyboard)
+
+	document := tgbotapi.NewDocumentUpload(user.TelegramID, tgbotapi.FileBytes{
+		Name:  fmt.Sprintf("practice-%d.ics", session.ID),
+		Bytes: []byte(practiceCalendar(session, partner)),
+	})
+	document.Caption = "Add the session to your calendar"
+	if _, err := bot.Send(document); err != nil {
+		log.Println("Error sending practice calendar file:", err)
+	}
+}
+
+// practiceCalendar builds an iCalendar event for the session
+func practiceCalendar(session *PracticeSession, partner *User) string {
+	const layout = "20060102T150405Z"
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Partner Go Bot//Practice Session//EN",
+		"BEGIN:VEVENT",
+		fmt.Sprintf("UID:practice-%d@partner-go-bot", session.ID),
+		"DTSTAMP:" + time.Now().UTC().Format(layout),
+		"DTSTART:" + session.StartsAt.UTC().Format(layout),
+		"DTEND:" + session.StartsAt.Add(PracticeSessionDuration).UTC().Format(layout),
+		"SUMMARY:English practice with " + strings.NewReplacer(",", "\\,", ";", "\\;").Replace(partner.Name),
+		"BEGIN:VALARM",
+		"TRIGGER:-PT10M",
+		"ACTION:DISPLAY",
+		"DESCRIPTION:English practice session",
+		"END:VALARM",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// declinePracticeSession declines a proposal and notifies the proposer
+func declinePracticeSession(bot *tgbotapi.BotAPI, callback *tgbotapi.CallbackQuery, user *User, sessionID uint) {
+	var session PracticeSession
+	if err := db.Where("id = ? AND partner_id = ? AND status = ?", sessionID, user.TelegramID, PracticeStatusProposed).First(&session).Error; err != nil {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "This proposal is not available anymore."))
+		return
+	}
+
+	db.Model(&session).Update("status", PracticeStatusDeclined)
+	bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "Declined"))
+	removeInlineKeyboard(bot, callback.Message)
+	sendMessage(bot, session.ProposerID, fmt.Sprintf("%s can not practice at the proposed times. 😔 Try proposing other times.", user.Name), mainKeyboard)
+}
+
+// sendPracticeReminder reminds both partners of an upcoming session
+func sendPracticeReminder(bot *tgbotapi.BotAPI, job *ScheduledJob) error {
+	var payload practiceJobPayload
+	if err := job.decodePayload(&payload); err != nil {
+		return err
+	}
+
+	var session PracticeSession
+	if err := db.Where("id = ? AND status = ?", payload.SessionID, PracticeStatusScheduled).First(&session).Error; err != nil {
+		// session was removed, nothing to remind
+		return nil
+	}
+
+	for _, telegramID := range []int64{session.ProposerID, session.PartnerID} {
+		var user, partner User
+		db.Where("telegram_id = ?", telegramID).First(&user)
+		db.Where("telegram_id = ?", session.otherUserID(telegramID)).First(&partner)
+
+		sendMessage(bot, telegramID, fmt.Sprintf("⏰ Your practice session with %s starts in %s (at %s).\n%s",
+			partner.Name, formatDuration(time.Duration(payload.Before)*time.Minute),
+			session.StartsAt.In(userLocation(&user)).Format("15:04"), partnerContactText(&partner)), mainKeyboard)
+	}
+	return nil
+}
+
+// askPracticeAttendance asks both partners if the session took place
+func askPracticeAttendance(bot *tgbotapi.BotAPI, job *ScheduledJob) error {
+	var payload practiceJobPayload
+	if err := job.decodePayload(&payload); err != nil {
+		return err
+	}
+
+	var session PracticeSession
+	if err := db.Where("id = ? AND status = ?", payload.SessionID, PracticeStatusScheduled).First(&session).Error; err != nil {
+		return nil
+	}
+	db.Model(&session).Update("status", PracticeStatusDone)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ We practiced", fmt.Sprintf("practice:attended:%d", session.ID)),
+			tgbotapi.NewInlineKeyboardButtonData("❌ Partner did not come", fmt.Sprintf("practice:missed:%d", session.ID)),
+		),
+	)
+	for _, telegramID := range []int64{session.ProposerID, session.PartnerID} {
+		var partner User
+		db.Where("telegram_id = ?", session.otherUserID(telegramID)).First(&partner)
+		sendMessage(bot, telegramID, fmt.Sprintf("📅 How was your practice session with %s?", partner.Name), keyboard)
+	}
+	return nil
+}
+
+// confirmPracticeAttendance stores the attendance answer of one side of the session
+func confirmPracticeAttendance(bot *tgbotapi.BotAPI, callback *tgbotapi.CallbackQuery, user *User, sessionID uint, attended bool) {
+	var session PracticeSession
+	if err := db.Where("id = ? AND status = ? AND (proposer_id = ? OR partner_id = ?)",
+		sessionID, PracticeStatusDone, user.TelegramID, user.TelegramID).First(&session).Error; err != nil {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "This session is not available anymore."))
+		return
+	}
+
+	// only the first answer is stored, a double tap does not count the session twice
+	column := "partner_confirmed"
+	if session.ProposerID == user.TelegramID {
+		column = "proposer_confirmed"
+	}
+	result := db.Model(&PracticeSession{}).Where("id = ? AND "+column+" IS NULL", session.ID).Update(column, attended)
+	if result.Error != nil || result.RowsAffected != 1 {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "You already answered."))
+		removeInlineKeyboard(bot, callback.Message)
+		return
+	}
+	if attended {
+		recordActivity(bot, user.TelegramID, ActivitySession)
+	}
+
+	bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "Thank you for your feedback!"))
+	removeInlineKeyboard(bot, callback.Message)
+
+	// the session is fresh in mind, ask for a rating now instead of waiting for the scheduled prompt
+	partnerID := session.otherUserID(user.TelegramID)
+	if ratingBlockedText(user.TelegramID, partnerID) == "" {
+		cancelJobs("rating_prompt", fmt.Sprintf("rating:%d:%d", user.TelegramID, partnerID))
+		sendRatingPrompt(bot, user.TelegramID, partnerID)
+	}
+}
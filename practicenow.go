@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// practice now settings
+const (
+	PracticeNowHeartbeatTTL    = time.Minute // a waiting user leaves the queue if the heartbeat is not renewed
+	PracticeNowSessionDuration = 10 * time.Minute
+	PracticeNowExtension       = 10 * time.Minute
+	PracticeNowMaxDuration     = 40 * time.Minute
+)
+
+// practice now redis keys, the queue is a sorted set of telegram IDs per level band scored by join time
+const (
+	practiceNowQueueKey   = "practice_now:queue:"   // + level band
+	practiceNowAliveKey   = "practice_now:alive:"   // + telegram ID, heartbeat of a waiting user
+	practiceNowUserKey    = "practice_now:user:"    // + telegram ID, session key of the user
+	practiceNowSessionKey = "practice_now:session:" // + session key, hash of the session
+)
+
+// practiceNowPairScript pops the oldest waiting user with a heartbeat from the queue, or adds the user to the queue.
+// Users without a heartbeat are dropped on the way, users blocked for the pair (ARGV[4] and later) stay in the queue.
+const practiceNowPairScript = `
+local excluded = {}
+for i = 4, #ARGV do
+	excluded[ARGV[i]] = true
+end
+local members = redis.call("zrange", KEYS[1], 0, -1)
+for _, member in ipairs(members) do
+	if member ~= ARGV[1] and not excluded[member] then
+		redis.call("zrem", KEYS[1], member)
+		if redis.call("exists", ARGV[3] .. member) == 1 then
+			return member
+		end
+	end
+end
+redis.call("zadd", KEYS[1], ARGV[2], ARGV[1])
+return false`
+
+// practice now jobs
+func init() {
+	registerJobHandler("practice_now_timeout", checkPracticeNowHeartbeat)
+	registerJobHandler("practice_now_end", endExpiredPracticeNowSession)
+}
+
+// practiceNowJobPayload is the payload of the practice now jobs
+type practiceNowJobPayload struct {
+	UserID     int64  // waiting user, timeout job only
+	Level      string // level band of the waiting user, timeout job only
+	SessionKey string // session, end job only
+}
+
+// practiceNowSession is a live anonymous relay chat between two users
+type practiceNowSession struct {
+	Key       string
+	UserIDs   [2]int64
+	StartedAt time.Time
+	EndsAt    time.Time
+}
+
+// partnerOf returns the other user of the session
+func (s *practiceNowSession) partnerOf(telegramID int64) int64 {
+	if s.UserIDs[0] == telegramID {
+		return s.UserIDs[1]
+	}
+	return s.UserIDs[0]
+}
+
+var practiceNowWaitingKeyboard = tgbotapi.NewInlineKeyboardMarkup(
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⏳ Keep waiting", "now:wait"),
+		tgbotapi.NewInlineKeyboardButtonData("🚪 Leave queue", "now:leave"),
+	),
+)
+
+var practiceNowSessionKeyboard = tgbotapi.NewInlineKeyboardMarkup(
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⏱ Extend", "now:extend"),
+		tgbotapi.NewInlineKeyboardButtonData("⛔ End", "now:end"),
+	),
+)
+
+// getPracticeNowSession returns the live session of the user, or nil
+func getPracticeNowSession(telegramID int64) *practiceNowSession {
+	ctx := context.Background()
+	key, err := redisClient.Get(ctx, practiceNowUserKey+strconv.FormatInt(telegramID, 10)).Result()
+	if err != nil {
+		if err != redis.Nil {
+			log.Println("Error getting practice now session:", err)
+		}
+		return nil
+	}
+	return loadPracticeNowSession(key)
+}
+
+// loadPracticeNowSession loads a session by its key, or nil if it ended
+func loadPracticeNowSession(key string) *practiceNowSession {
+	values, err := redisClient.HGetAll(context.Background(), practiceNowSessionKey+key).Result()
+	if err != nil || len(values) == 0 {
+		return nil
+	}
+
+	session := practiceNowSession{Key: key}
+	session.UserIDs[0], _ = strconv.ParseInt(values["a"], 10, 64)
+	session.UserIDs[1], _ = strconv.ParseInt(values["b"], 10, 64)
+	startedAt, _ := strconv.ParseInt(values["started_at"], 10, 64)
+	endsAt, _ := strconv.ParseInt(values["ends_at"], 10, 64)
+	session.StartedAt, session.EndsAt = time.Unix(startedAt, 0), time.Unix(endsAt, 0)
+	return &session
+}
+
+// handlePracticeNow puts the user in the live queue of the level band, or pairs the user at once
+func handlePracticeNow(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	if !user.EnglishLevel.Valid() {
+		sendMessage(bot, chatID, "Please set your English level first, partners are paired by level.", editEnglishLevelKeyboard)
+		return
+	}
+	if getPracticeNowSession(user.TelegramID) != nil {
+		sendMessage(bot, chatID, "You are already in a practice now chat, end it first.", mainKeyboard)
+		return
+	}
+	if blocked := livePracticeBlockedText(user); blocked != "" {
+		sendMessage(bot, chatID, blocked, mainKeyboard)
+		return
+	}
+
+	ctx := context.Background()
+	level := user.EnglishLevel.Band()
+	userID := strconv.FormatInt(user.TelegramID, 10)
+	if err := redisClient.Set(ctx, practiceNowAliveKey+userID, level, PracticeNowHeartbeatTTL).Err(); err != nil {
+		log.Println("Error setting practice now heartbeat:", err)
+		sendErrorMessage(bot, chatID, "Practice now is not available right now. Please try again.")
+		return
+	}
+
+	// users who hid or reported each other are never paired
+	args := []interface{}{userID, time.Now().Unix(), practiceNowAliveKey}
+	for _, blockedID := range getBlockedIDs(user.TelegramID) {
+		args = append(args, strconv.FormatInt(blockedID, 10))
+	}
+	partner, err := redisClient.Eval(ctx, practiceNowPairScript, []string{practiceNowQueueKey + level}, args...).Text()
+	if err != nil && err != redis.Nil {
+		log.Println("Error pairing practice now users:", err)
+		sendErrorMessage(bot, chatID, "Practice now is not available right now. Please try again.")
+		return
+	}
+
+	if partnerID, parseErr := strconv.ParseInt(partner, 10, 64); err == nil && parseErr == nil {
+		redisClient.Del(ctx, practiceNowAliveKey+userID, practiceNowAliveKey+partner)
+		startPracticeNowSession(bot, user.TelegramID, partnerID, level)
+		return
+	}
+
+	if err := scheduleJob("practice_now_timeout", "now:"+userID, time.Now().Add(PracticeNowHeartbeatTTL),
+		practiceNowJobPayload{UserID: user.TelegramID, Level: level}); err != nil {
+		log.Println("Error scheduling practice now timeout:", err)
+	}
+	sendMessage(bot, chatID, fmt.Sprintf("⚡ Looking for a %s partner to practice with right now...\nTap ⏳ Keep waiting at least every %s to stay in the queue.",
+		level, formatDuration(PracticeNowHeartbeatTTL)), practiceNowWaitingKeyboard)
+}
+
+// startPracticeNowSession opens an anonymous relay chat between the two users
+func startPracticeNowSession(bot *tgbotapi.BotAPI, userID, partnerID int64, level string) {
+	ctx := context.Background()
+	now := time.Now()
+	session := practiceNowSession{
+		Key:       newSessionKey(),
+		UserIDs:   [2]int64{userID, partnerID},
+		StartedAt: now,
+		EndsAt:    now.Add(PracticeNowSessionDuration),
+	}
+
+	// keys outlive the session a little, the end job removes them
+	ttl := PracticeNowMaxDuration + time.Hour
+	pipe := redisClient.TxPipeline()
+	pipe.HSet(ctx, practiceNowSessionKey+session.Key, map[string]interface{}{
+		"a":          userID,
+		"b":          partnerID,
+		"started_at": session.StartedAt.Unix(),
+		"ends_at":    session.EndsAt.Unix(),
+	})
+	pipe.Expire(ctx, practiceNowSessionKey+session.Key, ttl)
+	for _, id := range session.UserIDs {
+		pipe.Set(ctx, practiceNowUserKey+strconv.FormatInt(id, 10), session.Key, ttl)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Println("Error starting practice now session:", err)
+		for _, id := range session.UserIDs {
+			sendErrorMessage(bot, id, "Failed to start the practice chat. Please try again.")
+		}
+		return
+	}
+
+	// the users stopped waiting once the session started
+	for _, id := range session.UserIDs {
+		cancelJobs("practice_now_timeout", "now:"+strconv.FormatInt(id, 10))
+	}
+
+	if err := scheduleJob("practice_now_end", "now:"+session.Key, session.EndsAt, practiceNowJobPayload{SessionKey: session.Key}); err != nil {
+		log.Println("Error scheduling practice now end:", err)
+	}
+
+	for _, id := range session.UserIDs {
+		sendMessage(bot, id, fmt.Sprintf("⚡ You are connected with an anonymous %s partner for %s. Say hi! Your messages are sent by the bot.",
+			level, formatDuration(PracticeNowSessionDuration)), practiceNowSessionKeyboard)
+		recordActivity(bot, id, ActivitySession)
+	}
+}
+
+// relayPracticeNowMessage sends the text of the user to the partner of the live session
+func relayPracticeNowMessage(bot *tgbotapi.BotAPI, update tgbotapi.Update, session *practiceNowSession) {
+	if update.Message.Text == "" {
+		sendMessage(bot, update.Message.Chat.ID, "Only text messages can be sent.", practiceNowSessionKeyboard)
+		return
+	}
+	sendMessage(bot, session.partnerOf(update.Message.Chat.ID), "👤 "+update.Message.Text, practiceNowSessionKeyboard)
+	recordActivity(bot, update.Message.Chat.ID, ActivityMessage)
+}
+
+// handlePracticeNowCallback handles the queue and session buttons
+func handlePracticeNowCallback(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	callback := update.CallbackQuery
+	chatID := callback.Message.Chat.ID
+	userID := strconv.FormatInt(chatID, 10)
+	ctx := context.Background()
+
+	switch strings.TrimPrefix(callback.Data, "now:") {
+	case "wait":
+		renewed, err := redisClient.Expire(ctx, practiceNowAliveKey+userID, PracticeNowHeartbeatTTL).Result()
+		if err != nil || !renewed {
+			bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "You are not in the queue anymore."))
+			removeInlineKeyboard(bot, callback.Message)
+			return
+		}
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "Still looking..."))
+	case "leave":
+		leavePracticeNowQueue(chatID)
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "You left the queue."))
+		removeInlineKeyboard(bot, callback.Message)
+	case "extend":
+		session := getPracticeNowSession(chatID)
+		if session == nil {
+			bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "This chat has ended."))
+			return
+		}
+		if session.EndsAt.Add(PracticeNowExtension).Sub(session.StartedAt) > PracticeNowMaxDuration {
+			bot.AnswerCallbackQuery(tgbotapi.NewCallbackWithAlert(callback.ID,
+				fmt.Sprintf("A practice now chat can last up to %s. Send a follow request to keep practicing!", formatDuration(PracticeNowMaxDuration))))
+			return
+		}
+
+		session.EndsAt = session.EndsAt.Add(PracticeNowExtension)
+		redisClient.HSet(ctx, practiceNowSessionKey+session.Key, "ends_at", session.EndsAt.Unix())
+		cancelJobs("practice_now_end", "now:"+session.Key)
+		if err := scheduleJob("practice_now_end", "now:"+session.Key, session.EndsAt, practiceNowJobPayload{SessionKey: session.Key}); err != nil {
+			log.Println("Error scheduling practice now end:", err)
+		}
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "Extended"))
+		for _, id := range session.UserIDs {
+			sendMessage(bot, id, fmt.Sprintf("⏱ The chat was extended by %s.", formatDuration(PracticeNowExtension)), practiceNowSessionKeyboard)
+		}
+	case "end":
+		session := getPracticeNowSession(chatID)
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		if session != nil {
+			endPracticeNowSession(bot, session, "⛔ The chat was ended.")
+		}
+	default:
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+	}
+}
+
+// leavePracticeNowQueue removes the user from the queue of every level band
+func leavePracticeNowQueue(telegramID int64) {
+	ctx := context.Background()
+	userID := strconv.FormatInt(telegramID, 10)
+	redisClient.Del(ctx, practiceNowAliveKey+userID)
+	for _, band := range levelBands {
+		redisClient.ZRem(ctx, practiceNowQueueKey+band, userID)
+	}
+	cancelJobs("practice_now_timeout", "now:"+userID)
+}
+
+// endPracticeNowSession closes the session and notifies both users
+func endPracticeNowSession(bot *tgbotapi.BotAPI, session *practiceNowSession, reason string) {
+	ctx := context.Background()
+	keys := []string{practiceNowSessionKey + session.Key}
+	for _, id := range session.UserIDs {
+		keys = append(keys, practiceNowUserKey+strconv.FormatInt(id, 10))
+	}
+	redisClient.Del(ctx, keys...)
+	cancelJobs("practice_now_end", "now:"+session.Key)
+
+	for _, id := range session.UserIDs {
+		sendMessage(bot, id, reason+" Thanks for practicing! Tap ⚡ Practice now to meet someone new.", mainKeyboard)
+	}
+}
+
+// checkPracticeNowHeartbeat removes a waiting user whose heartbeat expired, or checks again later
+func checkPracticeNowHeartbeat(bot *tgbotapi.BotAPI, job *ScheduledJob) error {
+	var payload practiceNowJobPayload
+	if err := job.decodePayload(&payload); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	userID := strconv.FormatInt(payload.UserID, 10)
+	if _, err := redisClient.ZScore(ctx, practiceNowQueueKey+payload.Level, userID).Result(); err == redis.Nil {
+		return nil // paired or left
+	} else if err != nil {
+		return err
+	}
+
+	ttl, err := redisClient.TTL(ctx, practiceNowAliveKey+userID).Result()
+	if err != nil {
+		return err
+	}
+	if ttl > 0 {
+		return scheduleJob("practice_now_timeout", "now:"+userID, time.Now().Add(ttl), payload)
+	}
+
+	redisClient.ZRem(ctx, practiceNowQueueKey+payload.Level, userID)
+	sendMessage(bot, payload.UserID, "⌛ Nobody was found in time, you left the practice now queue. Try again later!", mainKeyboard)
+	return nil
+}
+
+// endExpiredPracticeNowSession ends a session when its time is over
+func endExpiredPracticeNowSession(bot *tgbotapi.BotAPI, job *ScheduledJob) error {
+	var payload practiceNowJobPayload
+	if err := job.decodePayload(&payload); err != nil {
+		return err
+	}
+
+	session := loadPracticeNowSession(payload.SessionKey)
+	if session == nil || time.Now().Before(session.EndsAt) {
+		return nil
+	}
+	endPracticeNowSession(bot, session, "⏰ Time is up, the chat has ended.")
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// profile link settings
+const (
+	ProfilePayloadPrefix = "p_"
+	MinProfileSlugLength = 3
+	MaxProfileSlugLength = 32
+)
+
+// profileSlugPattern matches the handles users can choose, start payloads allow only these characters
+var profileSlugPattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// profileSlug returns the public handle of the user, a handle is created from the name on first use
+func profileSlug(user *User) string {
+	if user.ProfileSlug != nil {
+		return *user.ProfileSlug
+	}
+
+	base := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return -1
+	}, strings.ToLower(user.Name))
+	if len(base) > MaxProfileSlugLength-7 {
+		base = base[:MaxProfileSlugLength-7]
+	}
+	if base == "" {
+		base = "partner"
+	}
+
+	// the random suffix makes handles of users with the same name unique
+	for i := 0; i < 5; i++ {
+		slug := base + "_" + newSessionKey()[:6]
+		if profileSlugTaken(slug, user.TelegramID) {
+			continue
+		}
+		// the unique index rejects a handle taken since the check
+		if err := db.Model(user).Update("profile_slug", slug).Error; err == nil {
+			user.ProfileSlug = &slug
+			return slug
+		}
+	}
+	log.Println("Error creating profile handle for user", user.TelegramID)
+	return ""
+}
+
+// profileSlugTaken checks if another user has the handle
+func profileSlugTaken(slug string, telegramID int64) bool {
+	var count int
+	db.Model(&User{}).Where("profile_slug = ? AND telegram_id != ?", slug, telegramID).Count(&count)
+	return count > 0
+}
+
+// profileLink returns the deep link that opens the partner card of the user
+func profileLink(bot *tgbotapi.BotAPI, user *User) string {
+	return fmt.Sprintf("https://t.me/%s?start=%s%s", bot.Self.UserName, ProfilePayloadPrefix, profileSlug(user))
+}
+
+// handleProfileLinkCommand shows the profile link of the user, /profilelink <handle> changes the handle
+func handleProfileLinkCommand(bot *tgbotapi.BotAPI, chatID int64, user *User, args string) {
+	if handle := strings.ToLower(strings.TrimSpace(args)); handle != "" {
+		if len(handle) < MinProfileSlugLength || len(handle) > MaxProfileSlugLength || !profileSlugPattern.MatchString(handle) {
+			sendErrorMessage(bot, chatID, fmt.Sprintf("A handle has %d to %d characters: letters a-z, digits and _.", MinProfileSlugLength, MaxProfileSlugLength))
+			return
+		}
+		if profileSlugTaken(handle, user.TelegramID) {
+			sendErrorMessage(bot, chatID, "This handle is already taken, please choose another one.")
+			return
+		}
+		if err := db.Model(user).Update("profile_slug", handle).Error; err != nil {
+			// another user took the handle since the check
+			if profileSlugTaken(handle, user.TelegramID) {
+				sendErrorMessage(bot, chatID, "This handle is already taken, please choose another one.")
+				return
+			}
+			log.Println("Error updating profile handle:", err)
+			sendErrorMessage(bot, chatID, "Failed to change your handle. Please try again.")
+			return
+		}
+		user.ProfileSlug = &handle
+	}
+
+	sendMessage(bot, chatID, fmt.Sprintf("🔗 Share your practice profile:\n%s\n\n"+
+		"Friends who open the link see your partner card and can follow you. Change your handle with /profilelink <handle>, old links stop working.",
+		profileLink(bot, user)), mainKeyboard)
+}
+
+// profileLinkBlockedText returns why the viewer can not see the profile of the owner, or "" if allowed
+func profileLinkBlockedText(viewer, owner *User) string {
+	if owner.Name == "" || owner.MobileNumber == "" {
+		return "This profile is not available."
+	}
+
+	// a user who skipped the other forever does not see or get seen again
+	var hidden int
+	db.Model(&HiddenPartner{}).Where("(user_id = ? AND hidden_id = ?) OR (user_id = ? AND hidden_id = ?)",
+		viewer.TelegramID, owner.TelegramID, owner.TelegramID, viewer.TelegramID).Count(&hidden)
+	if hidden > 0 {
+		return "This profile is not available."
+	}
+	return visibilityBlockedText(viewer, owner)
+}
+
+// openProfileLink shows the partner card of the owner of the handle to the viewer
+func openProfileLink(bot *tgbotapi.BotAPI, viewer *User, slug string) {
+	var owner User
+	if slug == "" || db.Where("profile_slug = ?", strings.ToLower(slug)).First(&owner).Error != nil {
+		sendMessage(bot, viewer.TelegramID, "This profile link is not valid anymore.", nil)
+		return
+	}
+	if owner.TelegramID == viewer.TelegramID {
+		showUserDetails(bot, viewer.TelegramID, viewer)
+		return
+	}
+	if blocked := profileLinkBlockedText(viewer, &owner); blocked != "" {
+		sendMessage(bot, viewer.TelegramID, blocked, nil)
+		return
+	}
+
+	// the card is a browsing session with one partner, so the card actions work as in Find Partner
+	session := startBrowseSession(viewer, []*User{&owner})
+	if session == nil {
+		sendErrorMessage(bot, viewer.TelegramID, "Failed to open the profile. Please try again.")
+		return
+	}
+
+	// the owner shared the link, so opening it does not use a daily partner view. The view is still stored:
+	// the owner sees it in Who viewed my profile (unless the viewer browses invisibly) and Find Partner skips the owner.
+	db.Create(&WatchList{UserID: viewer.TelegramID, WatchID: owner.TelegramID, Invisible: viewer.BrowseInvisibly})
+	session.markSeen(owner.TelegramID)
+	db.Save(session)
+	showPartnerDetail(bot, viewer.TelegramID, viewer, session, nil)
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+	_ "time/tzdata" // embed timezone database, user timezones must load on any host
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/jinzhu/gorm"
+)
+
+// user tiers, each tier has its own quota limits
+const (
+	TierFree    = "free"
+	TierPremium = "premium"
+	TierAdmin   = "admin"
+)
+
+// quota actions
+const (
+	QuotaActionView   = "view"   // watching a new partner card
+	QuotaActionFollow = "follow" // sending a follow request
+	QuotaActionLike   = "like"   // liking a partner in swipe mode
+	QuotaActionReroll = "reroll" // starting a new find partner search
+)
+
+// quota windows
+const (
+	QuotaWindowRolling     = "rolling"      // 24 hours from the first use in the window
+	QuotaWindowCalendarDay = "calendar_day" // resets at midnight in the user's timezone
+)
+
+// QuotaUnlimited is the limit of an action without quota
+const QuotaUnlimited = -1
+
+// quotaRule defines the window and the per tier limits of an action
+type quotaRule struct {
+	Title  string // shown in /limits
+	Name   string // shown in limit messages
+	Window string
+	Limits map[string]int
+}
+
+// quotaActions lists the quota actions in display order
+var quotaActions = []string{QuotaActionView, QuotaActionFollow, QuotaActionLike, QuotaActionReroll}
+
+// quotaRules holds the window and limits of every quota action
+var quotaRules = map[string]quotaRule{
+	QuotaActionView: {
+		Title:  "👀 Partner views",
+		Name:   "partner views",
+		Window: QuotaWindowRolling,
+		Limits: map[string]int{TierFree: 20, TierPremium: 100, TierAdmin: QuotaUnlimited},
+	},
+	QuotaActionFollow: {
+		Title:  "✅ Follow requests",
+		Name:   "follow requests",
+		Window: QuotaWindowCalendarDay,
+		Limits: map[string]int{TierFree: 10, TierPremium: 50, TierAdmin: QuotaUnlimited},
+	},
+	QuotaActionLike: {
+		Title:  "👍 Likes",
+		Name:   "likes",
+		Window: QuotaWindowCalendarDay,
+		Limits: map[string]int{TierFree: 50, TierPremium: 200, TierAdmin: QuotaUnlimited},
+	},
+	QuotaActionReroll: {
+		Title:  "🔄 New searches",
+		Name:   "new partner searches",
+		Window: QuotaWindowCalendarDay,
+		Limits: map[string]int{TierFree: 5, TierPremium: 30, TierAdmin: QuotaUnlimited},
+	},
+}
+
+// QuotaUsage stores how many times the user used an action in the current window
+type QuotaUsage struct {
+	ID          uint      `gorm:"primary_key"`
+	UserID      int64     `gorm:"unique_index:idx_quota_user_action"`
+	Action      string    `gorm:"unique_index:idx_quota_user_action"`
+	WindowStart time.Time // start of the current window
+	Count       int       // uses in the current window
+	UpdatedAt   time.Time
+}
+
+// QuotaBonus raises the limit of an action for a user until it expires, e.g. a referral reward
+type QuotaBonus struct {
+	ID        uint   `gorm:"primary_key"`
+	UserID    int64  `gorm:"index"`
+	Action    string // quota action the bonus applies to
+	Amount    int    // extra uses per window
+	Reason    string // why the bonus was granted, e.g. referral
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// QuotaStatus describes the state of a quota action for a user
+type QuotaStatus struct {
+	Action  string
+	Limit   int
+	Used    int
+	ResetAt time.Time
+}
+
+// Unlimited checks if the action has no limit for the user
+func (q QuotaStatus) Unlimited() bool {
+	return q.Limit == QuotaUnlimited
+}
+
+// Remaining returns how many uses are left in the current window
+func (q QuotaStatus) Remaining() int {
+	if q.Unlimited() || q.Used >= q.Limit {
+		return 0
+	}
+	return q.Limit - q.Used
+}
+
+// Allowed checks if the action can be used once more
+func (q QuotaStatus) Allowed() bool {
+	return q.Unlimited() || q.Used < q.Limit
+}
+
+// userTier returns the tier the quota limits of the user are taken from
+func userTier(user *User) string {
+	if isAdmin(user.TelegramID) {
+		return TierAdmin
+	}
+	if isPremium(user.TelegramID) {
+		return TierPremium
+	}
+	return TierFree
+}
+
+// quotaLimit returns the limit of the action for the user, including active bonuses
+func quotaLimit(user *User, action string) int {
+	limit := quotaRules[action].Limits[userTier(user)]
+	if limit == QuotaUnlimited {
+		return limit
+	}
+
+	var bonus struct{ Total int }
+	db.Model(&QuotaBonus{}).Select("coalesce(sum(amount), 0) AS total").
+		Where("user_id = ? AND action = ? AND expires_at > ?", user.TelegramID, action, time.Now()).Scan(&bonus)
+	return limit + bonus.Total
+}
+
+// grantQuotaBonus raises the limit of the action for the user by amount for the duration
+func grantQuotaBonus(telegramID int64, action string, amount int, duration time.Duration, reason string) error {
+	return db.Create(&QuotaBonus{
+		UserID:    telegramID,
+		Action:    action,
+		Amount:    amount,
+		Reason:    reason,
+		ExpiresAt: time.Now().Add(duration),
+	}).Error
+}
+
+// userLocation returns the timezone of the user, falling back to DEFAULT_TIMEZONE env and then UTC
+func userLocation(user *User) *time.Location {
+	for _, name := range []string{user.Timezone, os.Getenv("DEFAULT_TIMEZONE")} {
+		if name == "" {
+			continue
+		}
+		location, err := time.LoadLocation(name)
+		if err == nil {
+			return location
+		}
+		log.Println("Error loading timezone:", err)
+	}
+	return time.UTC
+}
+
+// quotaWindow returns the start and the end of the window that contains now for the usage
+func quotaWindow(user *User, rule quotaRule, usage *QuotaUsage, now time.Time) (time.Time, time.Time) {
+	if rule.Window == QuotaWindowCalendarDay {
+		local := now.In(userLocation(user))
+		start := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+		return start, start.AddDate(0, 0, 1)
+	}
+
+	// rolling window starts with the first use after the previous window ended
+	if usage != nil && !usage.WindowStart.IsZero() && now.Before(usage.WindowStart.Add(24*time.Hour)) {
+		return usage.WindowStart, usage.WindowStart.Add(24 * time.Hour)
+	}
+	return now, now.Add(24 * time.Hour)
+}
+
+// getQuotaUsage loads the usage row of the action and returns the start and the end of the current window.
+// FirstOrCreate stores an empty row with a zero window on the first call, also when only the status is shown,
+// a zero or ended window counts as unused. The row is not changed here, consumeQuota starts the new window.
+func getQuotaUsage(user *User, action string, rule quotaRule, now time.Time) (*QuotaUsage, time.Time, time.Time) {
+	var usage QuotaUsage
+	if err := db.Where(QuotaUsage{UserID: user.TelegramID, Action: action}).FirstOrCreate(&usage).Error; err != nil {
+		log.Println("Error loading quota usage:", err)
+		return nil, now, now.Add(24 * time.Hour)
+	}
+
+	start, end := quotaWindow(user, rule, &usage, now)
+	return &usage, start, end
+}
+
+// quotaUsed returns the uses stored in the usage row that belong to the window starting at start
+func quotaUsed(usage *QuotaUsage, start time.Time) int {
+	if !usage.WindowStart.Equal(start) {
+		return 0
+	}
+	return usage.Count
+}
+
+// getQuotaStatus returns the current quota state of the action for the user without using it
+func getQuotaStatus(user *User, action string) QuotaStatus {
+	rule := quotaRules[action]
+	status := QuotaStatus{Action: action, Limit: quotaLimit(user, action)}
+
+	usage, start, resetAt := getQuotaUsage(user, action, rule, time.Now())
+	status.ResetAt = resetAt
+	if usage != nil {
+		status.Used = quotaUsed(usage, start)
+	}
+	return status
+}
+
+// consumeQuota uses the action once if the user has quota left, returns the state after the attempt
+func consumeQuota(user *User, action string) (QuotaStatus, bool) {
+	rule := quotaRules[action]
+	status := QuotaStatus{Action: action, Limit: quotaLimit(user, action)}
+	if !status.Unlimited() && status.Limit <= 0 {
+		return status, false
+	}
+
+	// a request that lost the race to start the new window tries again in the window the other one started
+	for attempt := 0; attempt < 2; attempt++ {
+		usage, start, resetAt := getQuotaUsage(user, action, rule, time.Now())
+		status.ResetAt = resetAt
+		if usage == nil {
+			// do not block users because of a database error
+			return status, true
+		}
+		status.Used = quotaUsed(usage, start)
+
+		// one conditional update per use: increment in the current window only while under the limit,
+		// or start the new window with this use only if no other request started it since the row was loaded
+		query := db.Model(&QuotaUsage{}).Where("id = ?", usage.ID)
+		current := usage.WindowStart.Equal(start)
+		var updates map[string]interface{}
+		if current {
+			query = query.Where("window_start = ?", start)
+			if !status.Unlimited() {
+				query = query.Where("count < ?", status.Limit)
+			}
+			updates = map[string]interface{}{"count": gorm.Expr("count + 1")}
+		} else {
+			query = query.Where("window_start = ?", usage.WindowStart)
+			updates = map[string]interface{}{"window_start": start, "count": 1}
+		}
+
+		result := query.Updates(updates)
+		if result.Error != nil {
+			log.Println("Error consuming quota:", result.Error)
+			return status, true
+		}
+		if result.RowsAffected == 1 {
+			status.Used++
+			return status, true
+		}
+		if current {
+			return status, false
+		}
+	}
+	return status, false
+}
+
+// formatResetTime formats the reset time of a quota in the user's timezone
+func formatResetTime(user *User, resetAt time.Time) string {
+	location := userLocation(user)
+	left := time.Until(resetAt).Round(time.Minute)
+	if left < 0 {
+		left = 0
+	}
+	return fmt.Sprintf("%s (%s, in %s)", resetAt.In(location).Format("2006-01-02 15:04"), location.String(), formatDuration(left))
+}
+
+// formatDuration formats a duration as hours and minutes, e.g. 5h 12m
+func formatDuration(d time.Duration) string {
+	hours := int(d.Hours())
+	minutes := int(d.Minutes()) % 60
+	if hours > 0 {
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
+
+// quotaExceededText returns the message shown when the user reached the limit of an action
+func quotaExceededText(user *User, status QuotaStatus) string {
+	return fmt.Sprintf("🔒⏰ You have reached your daily limit of %s (%d). It resets at %s.",
+		quotaRules[status.Action].Name, status.Limit, formatResetTime(user, status.ResetAt))
+}
+
+// handleLimitsCommand shows the remaining quota and the reset time of every action
+func handleLimitsCommand(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	var lines []string
+	lines = append(lines, fmt.Sprintf("📊 Your limits (%s plan):", userTier(user)))
+
+	for _, action := range quotaActions {
+		status := getQuotaStatus(user, action)
+		title := quotaRules[action].Title
+		if status.Unlimited() {
+			lines = append(lines, fmt.Sprintf("\n%s: unlimited", title))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("\n%s: %d of %d left\nResets at %s", title, status.Remaining(), status.Limit, formatResetTime(user, status.ResetAt)))
+	}
+
+	sendMessage(bot, chatID, strings.Join(lines, "\n"), mainKeyboard)
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// rating settings
+const (
+	RatingPromptDelay      = 72 * time.Hour     // connected partners are asked to rate each other after this time
+	RatingMinConnectionAge = 24 * time.Hour     // a partner can be rated this long after connecting, or after a practice session
+	RatingMinAccountAge    = 3 * 24 * time.Hour // ratings of newer accounts do not count in the reputation
+	RatingEditWindow       = 7 * 24 * time.Hour // a rating can be changed this long after it was given
+	RatingDailyLimit       = 10                 // ratings a user can give per 24 hours
+	RatingPrior            = 3.5                // reputation of a partner without ratings
+	RatingPriorWeight      = 5                  // number of prior ratings, so a few ratings can not swing the reputation
+	RatingTopTags          = 2                  // tags shown on partner cards
+	ratingTagNoShow        = "no-show"
+)
+
+// ratingTags can be added to a rating
+var ratingTags = []string{"punctual", "patient", "helpful", "fun", ratingTagNoShow, "rude"}
+
+// PartnerRating is the rating a user gave to a connected partner
+type PartnerRating struct {
+	ID        uint   `gorm:"primary_key"`
+	RaterID   int64  `gorm:"unique_index:idx_rating_rater_ratee"` // telegram ID of the user who rated
+	RateeID   int64  `gorm:"unique_index:idx_rating_rater_ratee"` // telegram ID of the rated partner
+	Stars     int    // 1-5
+	Tags      string // comma separated ratingTags
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// the rating prompt job asks a user to rate a connected partner,
+// the reputation job counts the ratings of accounts that became old enough since the rating
+func init() {
+	registerJobHandler("rating_prompt", sendScheduledRatingPrompt)
+	registerRecurringJob("reputation", "20 * * * *", catchUpReputations)
+}
+
+// ratingJobPayload is the payload of the rating prompt job
+type ratingJobPayload struct {
+	RaterID int64
+	RateeID int64
+}
+
+// scheduleRatingPrompts asks both partners of a new connection to rate each other after RatingPromptDelay
+func scheduleRatingPrompts(userID, partnerID int64) {
+	runAt := time.Now().Add(RatingPromptDelay)
+	for _, pair := range [][2]int64{{userID, partnerID}, {partnerID, userID}} {
+		key := fmt.Sprintf("rating:%d:%d", pair[0], pair[1])
+		cancelJobs("rating_prompt", key)
+		if err := scheduleJob("rating_prompt", key, runAt, ratingJobPayload{RaterID: pair[0], RateeID: pair[1]}); err != nil {
+			log.Println("Error scheduling rating prompt:", err)
+		}
+	}
+}
+
+// sendScheduledRatingPrompt sends the rating prompt unless the partner is already rated or not connected anymore
+func sendScheduledRatingPrompt(bot *tgbotapi.BotAPI, job *ScheduledJob) error {
+	var payload ratingJobPayload
+	if err := job.decodePayload(&payload); err != nil {
+		return err
+	}
+
+	var count int
+	db.Model(&PartnerRating{}).Where("rater_id = ? AND ratee_id = ?", payload.RaterID, payload.RateeID).Count(&count)
+	if count > 0 || !isConnected(payload.RaterID, payload.RateeID) {
+		return nil
+	}
+	sendRatingPrompt(bot, payload.RaterID, payload.RateeID)
+	return nil
+}
+
+// sendRatingPrompt asks the rater for the stars of the partner
+func sendRatingPrompt(bot *tgbotapi.BotAPI, raterID, rateeID int64) {
+	var ratee User
+	if err := db.Where("telegram_id = ?", rateeID).First(&ratee).Error; err != nil {
+		return
+	}
+
+	var row []tgbotapi.InlineKeyboardButton
+	for stars := 1; stars <= 5; stars++ {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%d⭐", stars), fmt.Sprintf("rate:stars:%d:%d", rateeID, stars)))
+	}
+	sendMessage(bot, raterID, fmt.Sprintf("⭐ How was practicing with %s? Your rating helps others find good partners.", ratee.Name),
+		tgbotapi.NewInlineKeyboardMarkup(row))
+}
+
+// handleRateCommand lists the connected partners the user can rate
+func handleRateCommand(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, partnerID := range getConnectedPartnerIDs(user.TelegramID) {
+		if ratingBlockedText(user.TelegramID, partnerID) != "" {
+			continue
+		}
+		var partner User
+		if err := db.Where("telegram_id = ?", partnerID).First(&partner).Error; err != nil {
+			continue
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(partner.Name, fmt.Sprintf("rate:pick:%d", partnerID)),
+		))
+	}
+
+	if len(rows) == 0 {
+		sendMessage(bot, chatID, fmt.Sprintf("You have no partners to rate yet. You can rate a partner %s after connecting, or after a practice session.",
+			formatDuration(RatingMinConnectionAge)), mainKeyboard)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, "⭐ Which partner do you want to rate?")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	bot.Send(msg)
+}
+
+// ratingBlockedText returns why the rater can not rate the partner, or "" if allowed
+func ratingBlockedText(raterID, rateeID int64) string {
+	var followRequest FollowRequest
+	if err := db.Where("accepted = ? AND ((requester_id = ? AND target_id = ?) OR (requester_id = ? AND target_id = ?))",
+		true, raterID, rateeID, rateeID, raterID).First(&followRequest).Error; err != nil {
+		return "You can only rate partners you are connected with."
+	}
+
+	var practiced int
+	db.Model(&PracticeSession{}).Where("status = ? AND ((proposer_id = ? AND partner_id = ?) OR (proposer_id = ? AND partner_id = ?))",
+		PracticeStatusDone, raterID, rateeID, rateeID, raterID).Count(&practiced)
+	if practiced == 0 && time.Since(followRequest.UpdatedAt) < RatingMinConnectionAge {
+		return fmt.Sprintf("You can rate this partner in %s.", formatDuration(RatingMinConnectionAge-time.Since(followRequest.UpdatedAt)))
+	}
+
+	var rating PartnerRating
+	if err := db.Where("rater_id = ? AND ratee_id = ?", raterID, rateeID).First(&rating).Error; err == nil &&
+		time.Since(rating.CreatedAt) > RatingEditWindow {
+		return "You have already rated this partner."
+	}
+	return ""
+}
+
+// ratingTagsKeyboard builds the tag toggles of a rating
+func ratingTagsKeyboard(rating *PartnerRating) tgbotapi.InlineKeyboardMarkup {
+	selected := map[string]bool{}
+	for _, tag := range splitInterests(rating.Tags) {
+		selected[tag] = true
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+	for _, tag := range ratingTags {
+		title := tag
+		if selected[tag] {
+			title = "✅ " + tag
+		}
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(title, fmt.Sprintf("rate:tag:%d:%s", rating.RateeID, tag)))
+		if len(row) == 3 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("✔️ Done", fmt.Sprintf("rate:done:%d", rating.RateeID))))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// handleRatingCallback handles the partner choice, the stars and the tags of a rating
+func handleRatingCallback(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	callback := update.CallbackQuery
+	chatID := callback.Message.Chat.ID
+	parts := strings.Split(strings.TrimPrefix(callback.Data, "rate:"), ":")
+	if len(parts) < 2 {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+	rateeID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		log.Println("Invalid rating callback data:", callback.Data)
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+
+	switch {
+	case parts[0] == "pick":
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		removeInlineKeyboard(bot, callback.Message)
+		sendRatingPrompt(bot, chatID, rateeID)
+	case parts[0] == "stars" && len(parts) == 3:
+		stars, err := strconv.Atoi(parts[2])
+		if err != nil || stars < 1 || stars > 5 {
+			bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+			return
+		}
+		if blocked := ratingBlockedText(chatID, rateeID); blocked != "" {
+			bot.AnswerCallbackQuery(tgbotapi.NewCallbackWithAlert(callback.ID, blocked))
+			removeInlineKeyboard(bot, callback.Message)
+			return
+		}
+		var given int
+		db.Model(&PartnerRating{}).Where("rater_id = ? AND updated_at > ?", chatID, time.Now().Add(-24*time.Hour)).Count(&given)
+		if given >= RatingDailyLimit {
+			bot.AnswerCallbackQuery(tgbotapi.NewCallbackWithAlert(callback.ID, "You have given too many ratings today, please try again tomorrow."))
+			return
+		}
+
+		rating := PartnerRating{RaterID: chatID, RateeID: rateeID}
+		if err := db.Where(rating).Assign(PartnerRating{Stars: stars}).FirstOrCreate(&rating).Error; err != nil {
+			log.Println("Error saving partner rating:", err)
+			bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+			return
+		}
+		updateReputation(rateeID)
+
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "Saved"))
+		edit := tgbotapi.NewEditMessageText(chatID, callback.Message.MessageID,
+			fmt.Sprintf("You rated %s. Add tags that describe your partner:", strings.Repeat("⭐", stars)))
+		keyboard := ratingTagsKeyboard(&rating)
+		edit.ReplyMarkup = &keyboard
+		bot.Send(edit)
+	case parts[0] == "tag" && len(parts) == 3:
+		var rating PartnerRating
+		if err := db.Where("rater_id = ? AND ratee_id = ?", chatID, rateeID).First(&rating).Error; err != nil ||
+			time.Since(rating.CreatedAt) > RatingEditWindow {
+			bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+			return
+		}
+
+		var tags []string
+		found := false
+		for _, tag := range splitInterests(rating.Tags) {
+			if tag == parts[2] {
+				found = true
+				continue
+			}
+			tags = append(tags, tag)
+		}
+		if !found {
+			for _, tag := range ratingTags {
+				if tag == parts[2] {
+					tags = append(tags, tag)
+				}
+			}
+		}
+		rating.Tags = strings.Join(tags, ",")
+		db.Model(&rating).Update("tags", rating.Tags)
+
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		bot.Send(tgbotapi.NewEditMessageReplyMarkup(chatID, callback.Message.MessageID, ratingTagsKeyboard(&rating)))
+	case parts[0] == "done":
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "Thank you for your feedback!"))
+		removeInlineKeyboard(bot, callback.Message)
+	default:
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+	}
+}
+
+// updateReputation recomputes the reputation of the user as a Bayesian average of the counted ratings.
+// Ratings of accounts younger than RatingMinAccountAge are not counted.
+func updateReputation(telegramID int64) {
+	var result struct {
+		Count int
+		Sum   int
+	}
+	err := db.Raw(`SELECT count(*) AS count, coalesce(sum(partner_ratings.stars), 0) AS sum FROM partner_ratings
+		JOIN users ON users.telegram_id = partner_ratings.rater_id AND users.deleted_at IS NULL
+		WHERE partner_ratings.ratee_id = ? AND users.created_at < ?`,
+		telegramID, time.Now().Add(-RatingMinAccountAge)).Scan(&result).Error
+	if err != nil {
+		log.Println("Error computing reputation:", err)
+		return
+	}
+
+	reputation := (RatingPrior*RatingPriorWeight + float64(result.Sum)) / float64(RatingPriorWeight+result.Count)
+	db.Model(&User{}).Where("telegram_id = ?", telegramID).Updates(map[string]interface{}{
+		"reputation":   reputation,
+		"rating_count": result.Count,
+	})
+}
+
+// catchUpReputations recomputes the reputation of the users whose counted ratings changed since the last update,
+// i.e. ratings of accounts that were younger than RatingMinAccountAge when they rated
+func catchUpReputations(bot *tgbotapi.BotAPI) error {
+	var ratees []struct {
+		RateeID int64
+	}
+	err := db.Raw(`SELECT partner_ratings.ratee_id FROM partner_ratings
+		JOIN users raters ON raters.telegram_id = partner_ratings.rater_id AND raters.deleted_at IS NULL
+		JOIN users ratees ON ratees.telegram_id = partner_ratings.ratee_id
+		WHERE raters.created_at < ?
+		GROUP BY partner_ratings.ratee_id, ratees.rating_count HAVING count(*) != ratees.rating_count`,
+		time.Now().Add(-RatingMinAccountAge)).Scan(&ratees).Error
+	if err != nil {
+		return err
+	}
+
+	for _, ratee := range ratees {
+		updateReputation(ratee.RateeID)
+	}
+	return nil
+}
+
+// reputationScore returns the reputation used for ranking, partners without ratings get the prior
+func reputationScore(user *User) float64 {
+	if user.RatingCount == 0 {
+		return RatingPrior
+	}
+	return user.Reputation
+}
+
+// reputationText describes the reputation of the user for partner cards, e.g. "⭐ 4.3 (12 ratings) · punctual, patient"
+func reputationText(user *User) string {
+	if user.RatingCount == 0 {
+		return "⭐ No ratings yet"
+	}
+
+	var tags []string
+	db.Model(&PartnerRating{}).Where("ratee_id = ?", user.TelegramID).Pluck("tags", &tags)
+	counts := map[string]int{}
+	for _, ratingTags := range tags {
+		for _, tag := range splitInterests(ratingTags) {
+			counts[tag]++
+		}
+	}
+	topTags := make([]string, 0, len(counts))
+	for tag := range counts {
+		topTags = append(topTags, tag)
+	}
+	sort.Slice(topTags, func(i, j int) bool {
+		if counts[topTags[i]] != counts[topTags[j]] {
+			return counts[topTags[i]] > counts[topTags[j]]
+		}
+		return topTags[i] < topTags[j]
+	})
+	if len(topTags) > RatingTopTags {
+		topTags = topTags[:RatingTopTags]
+	}
+
+	text := fmt.Sprintf("⭐ %.1f (%d ratings)", user.Reputation, user.RatingCount)
+	if len(topTags) > 0 {
+		text += " · " + strings.Join(topTags, ", ")
+	}
+	return text
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// referral settings
+const (
+	ReferralPayloadPrefix  = "ref_"
+	ReferrerBonusViews     = 10                  // extra daily partner views for the referrer per registered friend
+	ReferrerBonusDuration  = 30 * 24 * time.Hour // how long the referrer bonus lasts
+	ReferredBonusViews     = 5                   // extra daily partner views for the new user
+	ReferredBonusDuration  = 7 * 24 * time.Hour
+	ReferralMaxRewards     = 5 // rewarded referrals per referrer in ReferralRewardWindow, more friends are welcome but not rewarded
+	ReferralRewardWindow   = 30 * 24 * time.Hour
+	ReferralTopReferrers   = 10 // referrers shown in the admin view
+	ReferralFunnelDaysBack = 30 // days of the admin funnel
+)
+
+// Referral attributes a user to the user whose invite link started the bot
+type Referral struct {
+	ID           uint       `gorm:"primary_key"`
+	ReferrerID   int64      `gorm:"index"`        // telegram ID of the user who shared the link
+	ReferredID   int64      `gorm:"unique_index"` // telegram ID of the new user, a user is referred once
+	RegisteredAt *time.Time // time the new user finished the registration
+	RewardedAt   *time.Time // time the rewards were granted, nil if not rewarded
+	CreatedAt    time.Time  // time the new user started the bot with the link
+}
+
+// handleStartPayload handles the deep link payload of /start, e.g. /start ref_<code> or /start p_<handle>.
+// isNew tells if the user had no account before this /start.
+func handleStartPayload(bot *tgbotapi.BotAPI, user *User, payload string, isNew bool) {
+	switch {
+	case strings.HasPrefix(payload, ReferralPayloadPrefix):
+		if isNew {
+			attributeReferral(user, strings.TrimPrefix(payload, ReferralPayloadPrefix))
+		}
+	case strings.HasPrefix(payload, ProfilePayloadPrefix):
+		slug := strings.TrimPrefix(payload, ProfilePayloadPrefix)
+		if isNew {
+			// the profile opens once the registration is finished
+			db.Model(user).Update("pending_profile_slug", slug)
+			return
+		}
+		openProfileLink(bot, user, slug)
+	default:
+		log.Println("Unknown start payload:", payload)
+	}
+}
+
+// attributeReferral stores the referrer of a new user
+func attributeReferral(user *User, code string) {
+	var referrer User
+	if code == "" || db.Where("referral_code = ?", code).First(&referrer).Error != nil || referrer.TelegramID == user.TelegramID {
+		return
+	}
+
+	referral := Referral{ReferrerID: referrer.TelegramID, ReferredID: user.TelegramID}
+	if err := db.Where(Referral{ReferredID: user.TelegramID}).FirstOrCreate(&referral).Error; err != nil {
+		log.Println("Error storing referral:", err)
+	}
+}
+
+// completeReferral rewards the referrer and the new user once the new user finishes the registration
+func completeReferral(bot *tgbotapi.BotAPI, user *User) {
+	var referral Referral
+	if err := db.Where("referred_id = ? AND registered_at IS NULL", user.TelegramID).First(&referral).Error; err != nil {
+		return
+	}
+	now := time.Now()
+	db.Model(&referral).Update("registered_at", &now)
+
+	var rewarded int
+	db.Model(&Referral{}).Where("referrer_id = ? AND rewarded_at > ?", referral.ReferrerID, now.Add(-ReferralRewardWindow)).Count(&rewarded)
+	if rewarded >= ReferralMaxRewards {
+		sendMessage(bot, referral.ReferrerID, fmt.Sprintf("🎉 %s joined with your invite link! You already got the maximum rewards for this month.", user.Name), nil)
+		return
+	}
+
+	if err := grantQuotaBonus(referral.ReferrerID, QuotaActionView, ReferrerBonusViews, ReferrerBonusDuration, "referral"); err != nil {
+		log.Println("Error granting referrer bonus:", err)
+		return
+	}
+	if err := grantQuotaBonus(user.TelegramID, QuotaActionView, ReferredBonusViews, ReferredBonusDuration, "referred"); err != nil {
+		log.Println("Error granting referred bonus:", err)
+	}
+	db.Model(&referral).Update("rewarded_at", &now)
+
+	sendMessage(bot, referral.ReferrerID, fmt.Sprintf("🎉 %s joined with your invite link! You get %d extra partner views per day for %d days.",
+		user.Name, ReferrerBonusViews, int(ReferrerBonusDuration.Hours()/24)), nil)
+	sendMessage(bot, user.TelegramID, fmt.Sprintf("🎁 You were invited by a friend, you get %d extra partner views per day for %d days.",
+		ReferredBonusViews, int(ReferredBonusDuration.Hours()/24)), nil)
+}
+
+// referralLink returns the invite link of the user, the referral code is created on first use
+func referralLink(bot *tgbotapi.BotAPI, user *User) string {
+	if user.ReferralCode == "" {
+		user.ReferralCode = newSessionKey()
+		db.Model(user).Update("referral_code", user.ReferralCode)
+	}
+	return fmt.Sprintf("https://t.me/%s?start=%s%s", bot.Self.UserName, ReferralPayloadPrefix, user.ReferralCode)
+}
+
+// handleInviteCommand shows the invite link of the user and the referral stats
+func handleInviteCommand(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	var started, registered int
+	db.Model(&Referral{}).Where("referrer_id = ?", user.TelegramID).Count(&started)
+	db.Model(&Referral{}).Where("referrer_id = ? AND registered_at IS NOT NULL", user.TelegramID).Count(&registered)
+
+	sendMessage(bot, chatID, fmt.Sprintf("🎁 Invite friends to practice with you!\n\n"+
+		"For every friend who registers with your link you get %d extra partner views per day for %d days (up to %d friends a month), "+
+		"and your friend gets %d extra views per day for %d days.\n\nYour link:\n%s\n\nFriends who joined: %d, registered: %d",
+		ReferrerBonusViews, int(ReferrerBonusDuration.Hours()/24), ReferralMaxRewards,
+		ReferredBonusViews, int(ReferredBonusDuration.Hours()/24), referralLink(bot, user), started, registered), mainKeyboard)
+}
+
+// handleReferralsCommand shows the referral funnel and the top referrers, only for admins
+func handleReferralsCommand(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	if !isAdmin(user.TelegramID) {
+		return
+	}
+
+	since := time.Now().AddDate(0, 0, -ReferralFunnelDaysBack)
+	var started, registered, rewarded int
+	db.Model(&Referral{}).Where("created_at > ?", since).Count(&started)
+	db.Model(&Referral{}).Where("created_at > ? AND registered_at IS NOT NULL", since).Count(&registered)
+	db.Model(&Referral{}).Where("created_at > ? AND rewarded_at IS NOT NULL", since).Count(&rewarded)
+
+	lines := []string{
+		fmt.Sprintf("📈 Referral funnel, last %d days:", ReferralFunnelDaysBack),
+		fmt.Sprintf("Started the bot: %d", started),
+		fmt.Sprintf("Registered: %d (%s)", registered, percentText(registered, started)),
+		fmt.Sprintf("Rewarded: %d (%s)", rewarded, percentText(rewarded, started)),
+		"",
+		"🏆 Top referrers (registered / started):",
+	}
+
+	var top []struct {
+		ReferrerID int64
+		Started    int
+		Registered int
+	}
+	db.Raw(`SELECT referrer_id, count(*) AS started, count(registered_at) AS registered FROM referrals
+		WHERE created_at > ? GROUP BY referrer_id ORDER BY registered DESC, started DESC LIMIT ?`, since, ReferralTopReferrers).Scan(&top)
+	for i, row := range top {
+		var referrer User
+		db.Where("telegram_id = ?", row.ReferrerID).First(&referrer)
+		lines = append(lines, fmt.Sprintf("%d. %s (%d): %d / %d", i+1, referrer.Name, row.ReferrerID, row.Registered, row.Started))
+	}
+	if len(top) == 0 {
+		lines = append(lines, "No referrals yet.")
+	}
+
+	sendMessage(bot, chatID, strings.Join(lines, "\n"), mainKeyboard)
+}
+
+// percentText formats part of total as a percentage
+func percentText(part, total int) string {
+	if total == 0 {
+		return "0%"
+	}
+	return fmt.Sprintf("%d%%", part*100/total)
+}
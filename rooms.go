@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// group practice room settings
+const (
+	RoomMinMembers      = 3
+	RoomMaxMembers      = 6
+	RoomSessionDuration = 45 * time.Minute
+	RoomWaitExpiry      = 2 * time.Hour // users leave the waiting pool if no group is formed in this time
+)
+
+// roomRules are posted in the room when a session starts
+const roomRules = "📜 Rules:\n" +
+	"1. Speak English only, in voice chat or messages.\n" +
+	"2. Give everyone a turn, keep answers short.\n" +
+	"3. Be kind, correct mistakes politely.\n" +
+	"The room is closed and all members are removed when the session ends."
+
+// PracticeRoom is a Telegram group linked by an admin, the bot uses it for group practice sessions
+type PracticeRoom struct {
+	ID        uint  `gorm:"primary_key"`
+	ChatID    int64 `gorm:"unique;not null"` // telegram ID of the group
+	Title     string
+	GroupID   uint // current RoomGroup, 0 when the room is free
+	CreatedAt time.Time
+}
+
+// RoomGroup is a group practice session held in a room
+type RoomGroup struct {
+	ID         uint   `gorm:"primary_key"`
+	RoomID     uint   `gorm:"index"`
+	Level      string // level band of the members
+	Interest   string
+	MemberIDs  string // comma separated telegram IDs of the members
+	TopicID    uint
+	StartsAt   time.Time
+	EndsAt     time.Time
+	ArchivedAt *time.Time
+}
+
+// RoomWaiting is a user waiting in the pool of a level band and an interest
+type RoomWaiting struct {
+	ID        uint   `gorm:"primary_key"`
+	UserID    int64  `gorm:"unique;not null"` // telegram ID of the user
+	Level     string `gorm:"index"`           // level band, one of levelBands
+	Interest  string `gorm:"index"`
+	CreatedAt time.Time
+}
+
+// the room jobs form groups every minute and close rooms when their session ends
+func init() {
+	registerRecurringJob("practice_rooms", "* * * * *", formRoomGroups)
+	registerJobHandler("practice_room_close", closeRoomGroup)
+}
+
+// roomJobPayload is the payload of the room close job
+type roomJobPayload struct {
+	GroupID uint
+}
+
+// handleGroupPractice shows the waiting pool state, or the interests to join a pool
+func handleGroupPractice(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	if !user.EnglishLevel.Valid() {
+		sendMessage(bot, chatID, "Please set your English level first, groups are formed by level.", editEnglishLevelKeyboard)
+		return
+	}
+	if blocked := livePracticeBlockedText(user); blocked != "" {
+		sendMessage(bot, chatID, blocked, mainKeyboard)
+		return
+	}
+
+	var waiting RoomWaiting
+	if err := db.Where("user_id = ?", user.TelegramID).First(&waiting).Error; err == nil {
+		var count int
+		db.Model(&RoomWaiting{}).Where("level = ? AND interest = ?", waiting.Level, waiting.Interest).Count(&count)
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("⏳ You are waiting for a %s group about %s (%d/%d people waiting). You will get an invite link when the group is formed.",
+			waiting.Level, waiting.Interest, count, RoomMinMembers))
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("🚪 Leave the pool", "room:leave")),
+		)
+		bot.Send(msg)
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+	for _, interest := range append([]string{GeneralInterest}, topicInterests()...) {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(interest, "room:join:"+interest))
+		if len(row) == 3 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("👥 Group practice: join a %s group of %d–%d people for %s. Choose a topic:",
+		user.EnglishLevel.Band(), RoomMinMembers, RoomMaxMembers, formatDuration(RoomSessionDuration)))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	bot.Send(msg)
+}
+
+// handleRoomCallback handles the join and leave buttons of the waiting pool
+func handleRoomCallback(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	callback := update.CallbackQuery
+	chatID := callback.Message.Chat.ID
+	data := strings.TrimPrefix(callback.Data, "room:")
+
+	var user User
+	if err := db.Where("telegram_id = ?", chatID).First(&user).Error; err != nil {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(data, "join:") && livePracticeBlockedText(&user) != "":
+		bot.AnswerCallbackQuery(tgbotapi.NewCallbackWithAlert(callback.ID, livePracticeBlockedText(&user)))
+		removeInlineKeyboard(bot, callback.Message)
+	case strings.HasPrefix(data, "join:") && user.EnglishLevel.Valid():
+		waiting := RoomWaiting{UserID: user.TelegramID, Level: user.EnglishLevel.Band(), Interest: strings.TrimPrefix(data, "join:")}
+		if err := db.Where(RoomWaiting{UserID: user.TelegramID}).Assign(waiting).FirstOrCreate(&waiting).Error; err != nil {
+			log.Println("Error joining room waiting pool:", err)
+			bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+			return
+		}
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "Joined"))
+		bot.Send(tgbotapi.NewEditMessageText(chatID, callback.Message.MessageID,
+			fmt.Sprintf("⏳ You joined the %s pool about %s. You will get an invite link when %d people are waiting.",
+				waiting.Level, waiting.Interest, RoomMinMembers)))
+	case data == "leave":
+		db.Where("user_id = ?", user.TelegramID).Delete(&RoomWaiting{})
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "You left the pool"))
+		removeInlineKeyboard(bot, callback.Message)
+	default:
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+	}
+}
+
+// formRoomGroups forms groups of the waiting users with the same level band and interest, while free rooms exist
+func formRoomGroups(bot *tgbotapi.BotAPI) error {
+	if err := db.Where("created_at < ?", time.Now().Add(-RoomWaitExpiry)).Delete(&RoomWaiting{}).Error; err != nil {
+		return err
+	}
+
+	var pools []RoomWaiting
+	if err := db.Model(&RoomWaiting{}).Select("level, interest").Group("level, interest").
+		Having("count(*) >= ?", RoomMinMembers).Scan(&pools).Error; err != nil {
+		return err
+	}
+
+	// rooms failing to start a group, e.g. when the bot lost its admin rights, are skipped in this run
+	var brokenRoomIDs []uint
+	for _, pool := range pools {
+		for {
+			var waiting []RoomWaiting
+			db.Where("level = ? AND interest = ?", pool.Level, pool.Interest).Order("created_at").Limit(RoomMaxMembers).Find(&waiting)
+			if len(waiting) < RoomMinMembers {
+				break
+			}
+
+			var room PracticeRoom
+			query := db.Where("group_id = ?", 0)
+			if len(brokenRoomIDs) > 0 {
+				query = query.Where("id NOT IN (?)", brokenRoomIDs)
+			}
+			if err := query.First(&room).Error; err != nil {
+				log.Printf("No free practice room for the %s %s pool", pool.Level, pool.Interest)
+				return nil
+			}
+			if err := startRoomGroup(bot, &room, pool.Level, pool.Interest, waiting); err != nil {
+				log.Printf("Error starting a group in practice room %d (%s): %v", room.ChatID, room.Title, err)
+				brokenRoomIDs = append(brokenRoomIDs, room.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// startRoomGroup starts a session in the room for the waiting users, sends them the invite link and posts the topic
+func startRoomGroup(bot *tgbotapi.BotAPI, room *PracticeRoom, level, interest string, waiting []RoomWaiting) error {
+	inviteLink, err := bot.GetInviteLink(tgbotapi.ChatConfig{ChatID: room.ChatID})
+	if err != nil {
+		return fmt.Errorf("exporting invite link of room %d: %v", room.ChatID, err)
+	}
+
+	memberIDs := make([]int64, 0, len(waiting))
+	for _, member := range waiting {
+		memberIDs = append(memberIDs, member.UserID)
+	}
+
+	var topic Topic
+	query := db.Where("level = ?", level)
+	if interest != GeneralInterest {
+		query = query.Where("interest IN (?)", []string{interest, GeneralInterest})
+	}
+	query.Order("random()").First(&topic)
+
+	now := time.Now()
+	group := RoomGroup{
+		RoomID:    room.ID,
+		Level:     level,
+		Interest:  interest,
+		MemberIDs: joinIDs(memberIDs),
+		TopicID:   topic.ID,
+		StartsAt:  now,
+		EndsAt:    now.Add(RoomSessionDuration),
+	}
+	if err := db.Create(&group).Error; err != nil {
+		return err
+	}
+	db.Model(room).Update("group_id", group.ID)
+	db.Where("user_id IN (?)", memberIDs).Delete(&RoomWaiting{})
+
+	if err := scheduleJob("practice_room_close", fmt.Sprintf("room:%d", group.ID), group.EndsAt, roomJobPayload{GroupID: group.ID}); err != nil {
+		log.Println("Error scheduling room close:", err)
+	}
+
+	for _, memberID := range memberIDs {
+		sendMessage(bot, memberID, fmt.Sprintf("👥 Your %s group about %s is ready! Join now, the session lasts %s:\n%s",
+			level, interest, formatDuration(RoomSessionDuration), inviteLink), mainKeyboard)
+		recordActivity(bot, memberID, ActivitySession)
+	}
+
+	text := fmt.Sprintf("👋 Welcome! This %s session ends at %s UTC.\n\n%s", level, group.EndsAt.UTC().Format("15:04"), roomRules)
+	if topic.ID != 0 {
+		text += "\n\n💡 Topic: " + topic.Text
+	}
+	sendMessage(bot, room.ChatID, text, nil)
+	return nil
+}
+
+// closeRoomGroup ends the session of a room: members are removed, the invite link is revoked and the room is freed
+func closeRoomGroup(bot *tgbotapi.BotAPI, job *ScheduledJob) error {
+	var payload roomJobPayload
+	if err := job.decodePayload(&payload); err != nil {
+		return err
+	}
+
+	var group RoomGroup
+	if err := db.First(&group, payload.GroupID).Error; err != nil || group.ArchivedAt != nil {
+		return nil
+	}
+	var room PracticeRoom
+	if err := db.First(&room, group.RoomID).Error; err != nil {
+		return nil
+	}
+
+	sendMessage(bot, room.ChatID, "⏰ The session is over, thank you for practicing! The room is closing now.", nil)
+	for _, memberID := range splitIDs(group.MemberIDs) {
+		removeRoomMember(bot, room.ChatID, memberID)
+	}
+
+	// exporting a new invite link revokes the link sent to the members
+	if _, err := bot.GetInviteLink(tgbotapi.ChatConfig{ChatID: room.ChatID}); err != nil {
+		log.Println("Error revoking room invite link:", err)
+	}
+
+	now := time.Now()
+	db.Model(&group).Update("archived_at", &now)
+	db.Model(&room).Update("group_id", 0)
+	return nil
+}
+
+// removeRoomMember removes the user from the room, the user can join again with a new invite link
+func removeRoomMember(bot *tgbotapi.BotAPI, chatID, userID int64) {
+	member := tgbotapi.ChatMemberConfig{ChatID: chatID, UserID: int(userID)}
+	if _, err := bot.KickChatMember(tgbotapi.KickChatMemberConfig{ChatMemberConfig: member}); err != nil {
+		log.Println("Error removing room member:", err)
+		return
+	}
+	bot.UnbanChatMember(member)
+}
+
+// handleGroupMessage handles messages in group chats: room linking by admins and room joins
+func handleGroupMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if message.NewChatMembers != nil {
+		checkRoomMembers(bot, message)
+		return
+	}
+	if message.From == nil || !isAdmin(int64(message.From.ID)) {
+		return
+	}
+
+	switch message.Command() {
+	case "linkroom":
+		room := PracticeRoom{ChatID: message.Chat.ID, Title: message.Chat.Title}
+		if err := db.Where(PracticeRoom{ChatID: message.Chat.ID}).FirstOrCreate(&room).Error; err != nil {
+			log.Println("Error linking practice room:", err)
+			return
+		}
+		sendMessage(bot, message.Chat.ID, "✅ This group is now a practice room. Make the bot an admin that can invite and ban users.", nil)
+	case "unlinkroom":
+		var room PracticeRoom
+		if err := db.Where("chat_id = ?", message.Chat.ID).First(&room).Error; err != nil {
+			return
+		}
+		if room.GroupID != 0 {
+			sendMessage(bot, message.Chat.ID, "A session is running in this room, unlink it after the session.", nil)
+			return
+		}
+		db.Delete(&room)
+		sendMessage(bot, message.Chat.ID, "This group is not a practice room anymore.", nil)
+	}
+}
+
+// checkRoomMembers removes users who joined a room without being members of its current group
+func checkRoomMembers(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	var room PracticeRoom
+	if err := db.Where("chat_id = ?", message.Chat.ID).First(&room).Error; err != nil {
+		return
+	}
+
+	allowed := map[int64]bool{}
+	var group RoomGroup
+	if room.GroupID != 0 && db.First(&group, room.GroupID).Error == nil {
+		for _, memberID := range splitIDs(group.MemberIDs) {
+			allowed[memberID] = true
+		}
+	}
+
+	for _, newMember := range *message.NewChatMembers {
+		userID := int64(newMember.ID)
+		if newMember.IsBot || allowed[userID] || isAdmin(userID) {
+			continue
+		}
+		removeRoomMember(bot, message.Chat.ID, userID)
+	}
+}
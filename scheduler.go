@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/jinzhu/gorm"
+)
+
+// scheduler settings
+const (
+	SchedulerPollInterval  = 5 * time.Second
+	SchedulerLeaderTTL     = 30 * time.Second // leader lock expires if the instance stops renewing it
+	SchedulerJobLockTTL    = 5 * time.Minute  // a claimed job is retried if its run did not finish in this time
+	SchedulerBatchSize     = 20
+	SchedulerMaxAttempts   = 5
+	SchedulerMaxBackoff    = time.Hour
+	SchedulerBaseBackoff   = 30 * time.Second
+	schedulerLeaderLockKey = "scheduler:leader"
+	schedulerLastRunKey    = "scheduler:last_run:" // + job name, holds the last minute the recurring job was started
+)
+
+// ScheduledJob is a persistent delayed job, it runs once at RunAt and is retried with backoff on error
+type ScheduledJob struct {
+	ID          uint      `gorm:"primary_key"`
+	Name        string    `gorm:"index"` // registered job handler name
+	Key         string    `gorm:"index"` // optional key to find or cancel the job, e.g. a session ID
+	Payload     string    // JSON encoded job data
+	RunAt       time.Time `gorm:"index"`
+	Attempts    int
+	MaxAttempts int
+	LastError   string
+	LockedUntil *time.Time
+	DoneAt      *time.Time
+	FailedAt    *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// decodePayload decodes the JSON payload of the job into v
+func (j *ScheduledJob) decodePayload(v interface{}) error {
+	return json.Unmarshal([]byte(j.Payload), v)
+}
+
+// jobHandler runs a delayed job, returning an error schedules a retry
+type jobHandler func(bot *tgbotapi.BotAPI, job *ScheduledJob) error
+
+// recurringJob runs on a cron schedule (evaluated in UTC)
+type recurringJob struct {
+	Name     string
+	Schedule *cronSchedule
+	Run      func(bot *tgbotapi.BotAPI) error
+}
+
+var (
+	jobHandlers   = map[string]jobHandler{}
+	recurringJobs []recurringJob
+	schedulerID   = newSessionKey() // identifies this instance in the leader lock
+
+	// runningRecurringJobs holds the names of the recurring jobs running in this instance
+	runningRecurringJobs sync.Map
+)
+
+// registerJobHandler registers the handler of delayed jobs with the given name, features call it from init
+func registerJobHandler(name string, handler jobHandler) {
+	if _, exists := jobHandlers[name]; exists {
+		log.Panicf("job handler %s is already registered", name)
+	}
+	jobHandlers[name] = handler
+}
+
+// registerRecurringJob registers a job running on a cron spec (minute hour day-of-month month day-of-week),
+// features call it from init
+func registerRecurringJob(name, spec string, run func(bot *tgbotapi.BotAPI) error) {
+	schedule, err := parseCron(spec)
+	if err != nil {
+		log.Panicf("invalid cron spec of job %s: %v", name, err)
+	}
+	recurringJobs = append(recurringJobs, recurringJob{Name: name, Schedule: schedule, Run: run})
+}
+
+// scheduleJob stores a delayed job that runs at runAt
+func scheduleJob(name, key string, runAt time.Time, payload interface{}) error {
+	if _, exists := jobHandlers[name]; !exists {
+		return fmt.Errorf("no job handler registered for %s", name)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	job := ScheduledJob{
+		Name:        name,
+		Key:         key,
+		Payload:     string(data),
+		RunAt:       runAt,
+		MaxAttempts: SchedulerMaxAttempts,
+	}
+	return db.Create(&job).Error
+}
+
+// cancelJobs removes the pending delayed jobs with the given name and key
+func cancelJobs(name, key string) {
+	if err := db.Where("name = ? AND key = ? AND done_at IS NULL AND failed_at IS NULL", name, key).Delete(&ScheduledJob{}).Error; err != nil {
+		log.Println("Error canceling scheduled jobs:", err)
+	}
+}
+
+// startScheduler runs the scheduler in background. Only the instance holding the leader lock in Redis runs jobs.
+func startScheduler(bot *tgbotapi.BotAPI) {
+	go func() {
+		ticker := time.NewTicker(SchedulerPollInterval)
+		defer ticker.Stop()
+
+		var lastCronMinute time.Time
+		for now := range ticker.C {
+			if !acquireSchedulerLeadership() {
+				continue
+			}
+
+			minute := now.UTC().Truncate(time.Minute)
+			if minute.After(lastCronMinute) {
+				lastCronMinute = minute
+				runRecurringJobs(bot, minute)
+			}
+
+			runDueJobs(bot)
+		}
+	}()
+}
+
+// acquireSchedulerLeadership takes or renews the leader lock of this instance
+func acquireSchedulerLeadership() bool {
+	ctx := context.Background()
+
+	ok, err := redisClient.SetNX(ctx, schedulerLeaderLockKey, schedulerID, SchedulerLeaderTTL).Result()
+	if err != nil {
+		log.Println("Error acquiring scheduler leader lock:", err)
+		return false
+	}
+	if ok {
+		return true
+	}
+
+	// renew the lock only if this instance holds it
+	renewed, err := redisClient.Eval(ctx, `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`,
+		[]string{schedulerLeaderLockKey}, schedulerID, SchedulerLeaderTTL.Milliseconds()).Int()
+	if err != nil && err != redis.Nil {
+		log.Println("Error renewing scheduler leader lock:", err)
+		return false
+	}
+	return renewed == 1
+}
+
+// runRecurringJobs starts the recurring jobs matching the minute without waiting for them, so a slow job does not
+// hold up the leader lock renewal and the delayed jobs. A job still running from an earlier minute is not started again.
+func runRecurringJobs(bot *tgbotapi.BotAPI, minute time.Time) {
+	for _, job := range recurringJobs {
+		if !job.Schedule.matches(minute) {
+			continue
+		}
+		if _, running := runningRecurringJobs.LoadOrStore(job.Name, true); running {
+			log.Printf("Recurring job %s is still running, skipping %s", job.Name, minute.Format("15:04"))
+			continue
+		}
+		if !claimRecurringMinute(job.Name, minute) {
+			runningRecurringJobs.Delete(job.Name)
+			continue
+		}
+
+		go func(job recurringJob) {
+			defer runningRecurringJobs.Delete(job.Name)
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("Recurring job %s panicked: %v", job.Name, r)
+				}
+			}()
+			if err := job.Run(bot); err != nil {
+				log.Printf("Recurring job %s failed: %v", job.Name, err)
+			}
+		}(job)
+	}
+}
+
+// claimRecurringMinute stores the minute as the last run of the recurring job, it returns false if the job already ran
+// at this minute, e.g. on the previous leader before a failover
+func claimRecurringMinute(name string, minute time.Time) bool {
+	claimed, err := redisClient.Eval(context.Background(),
+		`local last = tonumber(redis.call("get", KEYS[1]) or "0") if last >= tonumber(ARGV[1]) then return 0 end redis.call("set", KEYS[1], ARGV[1]) return 1`,
+		[]string{schedulerLastRunKey + name}, minute.Unix()).Int()
+	if err != nil {
+		log.Printf("Error claiming minute of recurring job %s: %v", name, err)
+		return false
+	}
+	return claimed == 1
+}
+
+// runDueJobs claims and runs the delayed jobs whose time has come
+func runDueJobs(bot *tgbotapi.BotAPI) {
+	var jobs []ScheduledJob
+	// claim the jobs atomically, so a job is never run by two instances
+	err := db.Raw(`UPDATE scheduled_jobs SET locked_until = ?, updated_at = ?
+		WHERE id IN (
+			SELECT id FROM scheduled_jobs
+			WHERE done_at IS NULL AND failed_at IS NULL AND run_at <= ? AND (locked_until IS NULL OR locked_until < ?)
+			ORDER BY run_at LIMIT ? FOR UPDATE SKIP LOCKED
+		) RETURNING *`,
+		time.Now().Add(SchedulerJobLockTTL), time.Now(), time.Now(), time.Now(), SchedulerBatchSize).Scan(&jobs).Error
+	if err != nil {
+		log.Println("Error claiming scheduled jobs:", err)
+		return
+	}
+
+	for i := range jobs {
+		runJob(bot, &jobs[i])
+	}
+}
+
+// runJob runs one delayed job and stores the result, failed jobs are retried with exponential backoff
+func runJob(bot *tgbotapi.BotAPI, job *ScheduledJob) {
+	handler, exists := jobHandlers[job.Name]
+	var err error
+	if !exists {
+		err = fmt.Errorf("no job handler registered for %s", job.Name)
+	} else {
+		err = runJobHandler(bot, handler, job)
+	}
+
+	now := time.Now()
+	job.Attempts++
+	updates := map[string]interface{}{"attempts": job.Attempts, "locked_until": gorm.Expr("NULL")}
+	if err == nil {
+		updates["done_at"] = &now
+		updates["last_error"] = ""
+	} else {
+		log.Printf("Scheduled job %s #%d failed: %v", job.Name, job.ID, err)
+		updates["last_error"] = err.Error()
+		if job.Attempts >= job.MaxAttempts {
+			updates["failed_at"] = &now
+		} else {
+			updates["run_at"] = now.Add(jobBackoff(job.Attempts))
+		}
+	}
+
+	// a job canceled while it ran is deleted, it must not be stored again
+	result := db.Model(&ScheduledJob{}).Where("id = ?", job.ID).Updates(updates)
+	if result.Error != nil {
+		log.Printf("Error storing result of scheduled job %s #%d: %v", job.Name, job.ID, result.Error)
+	} else if result.RowsAffected == 0 {
+		log.Printf("Scheduled job %s #%d was canceled while running", job.Name, job.ID)
+	}
+}
+
+// runJobHandler runs the handler and turns a panic into an error
+func runJobHandler(bot *tgbotapi.BotAPI, handler jobHandler, job *ScheduledJob) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(bot, job)
+}
+
+// jobBackoff returns the delay before the next attempt of a failed job
+func jobBackoff(attempts int) time.Duration {
+	backoff := SchedulerBaseBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= SchedulerMaxBackoff {
+			return SchedulerMaxBackoff
+		}
+	}
+	return backoff
+}
+
+// cronSchedule is a parsed cron spec, every field holds the allowed values
+type cronSchedule struct {
+	minute, hour, dayOfMonth, month, dayOfWeek map[int]bool
+	anyDayOfMonth, anyDayOfWeek                bool // the day field starts with *
+}
+
+// parseCron parses a 5 field cron spec, fields support *, */n, a-b, a-b/n and comma separated lists
+func parseCron(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron spec %q must have 5 fields", spec)
+	}
+
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+	var sets [5]map[int]bool
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("cron spec %q: %v", spec, err)
+		}
+		sets[i] = set
+	}
+	return &cronSchedule{minute: sets[0], hour: sets[1], dayOfMonth: sets[2], month: sets[3], dayOfWeek: sets[4],
+		anyDayOfMonth: strings.HasPrefix(fields[2], "*"), anyDayOfWeek: strings.HasPrefix(fields[4], "*")}, nil
+}
+
+// parseCronField parses one cron field into the set of allowed values
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	set := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			parsed, err := strconv.Atoi(part[i+1:])
+			if err != nil || parsed <= 0 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+			step = parsed
+			part = part[:i]
+		}
+
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			parsed, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", part)
+			}
+			from, to = parsed, parsed
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid range %q", part)
+				}
+			} else if step > 1 {
+				to = max
+			}
+		}
+		if from < min || to > max || from > to {
+			return nil, fmt.Errorf("value %q out of range %d-%d", part, min, max)
+		}
+
+		for value := from; value <= to; value += step {
+			set[value] = true
+		}
+	}
+	return set, nil
+}
+
+// matches checks if the schedule runs at the minute of t (in UTC). As in standard cron, when both day-of-month
+// and day-of-week are restricted the day matches either of them, e.g. "0 9 1 * 1" runs on the 1st and on Mondays.
+func (c *cronSchedule) matches(t time.Time) bool {
+	t = t.UTC()
+	if !c.minute[t.Minute()] || !c.hour[t.Hour()] || !c.month[int(t.Month())] {
+		return false
+	}
+	dayOfMonth, dayOfWeek := c.dayOfMonth[t.Day()], c.dayOfWeek[int(t.Weekday())]
+	if c.anyDayOfMonth || c.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
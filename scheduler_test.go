@@ -0,0 +1,133 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field    string
+		min, max int
+		want     []int
+		wantErr  bool
+	}{
+		{field: "*", min: 0, max: 6, want: []int{0, 1, 2, 3, 4, 5, 6}},
+		{field: "5", min: 0, max: 59, want: []int{5}},
+		{field: "1-3", min: 0, max: 23, want: []int{1, 2, 3}},
+		{field: "*/15", min: 0, max: 59, want: []int{0, 15, 30, 45}},
+		{field: "10-20/5", min: 0, max: 59, want: []int{10, 15, 20}},
+		{field: "50/5", min: 0, max: 59, want: []int{50, 55}},
+		{field: "1,3,5-6", min: 0, max: 6, want: []int{1, 3, 5, 6}},
+		{field: "0", min: 1, max: 31, wantErr: true},
+		{field: "60", min: 0, max: 59, wantErr: true},
+		{field: "5-1", min: 0, max: 59, wantErr: true},
+		{field: "1-13", min: 1, max: 12, wantErr: true},
+		{field: "*/0", min: 0, max: 59, wantErr: true},
+		{field: "*/x", min: 0, max: 59, wantErr: true},
+		{field: "a", min: 0, max: 59, wantErr: true},
+		{field: "1-b", min: 0, max: 59, wantErr: true},
+	}
+
+	for _, test := range tests {
+		set, err := parseCronField(test.field, test.min, test.max)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseCronField(%q, %d, %d) = %v, want error", test.field, test.min, test.max, set)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCronField(%q, %d, %d) error: %v", test.field, test.min, test.max, err)
+			continue
+		}
+		want := map[int]bool{}
+		for _, value := range test.want {
+			want[value] = true
+		}
+		if !reflect.DeepEqual(set, want) {
+			t.Errorf("parseCronField(%q, %d, %d) = %v, want %v", test.field, test.min, test.max, set, want)
+		}
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		spec    string
+		times   map[string]bool // UTC time in RFC 3339 -> want match
+		wantErr bool
+	}{
+		{spec: "*/10 * * * *", times: map[string]bool{
+			"2024-03-04T10:20:00Z": true,
+			"2024-03-04T10:25:00Z": false,
+		}},
+		{spec: "15 0 * * 1", times: map[string]bool{
+			"2024-03-04T00:15:00Z": true,  // Monday
+			"2024-03-05T00:15:00Z": false, // Tuesday
+			"2024-03-04T01:15:00Z": false,
+		}},
+		{spec: "0 9 1 * *", times: map[string]bool{
+			"2024-03-01T09:00:00Z": true,
+			"2024-03-04T09:00:00Z": false,
+		}},
+		// day-of-month and day-of-week both restricted: either matches
+		{spec: "0 9 1 * 1", times: map[string]bool{
+			"2024-03-01T09:00:00Z": true, // Friday the 1st
+			"2024-03-04T09:00:00Z": true, // Monday the 4th
+			"2024-03-05T09:00:00Z": false,
+		}},
+		{spec: "0 9 * 2 *", times: map[string]bool{
+			"2024-02-10T09:00:00Z": true,
+			"2024-03-10T09:00:00Z": false,
+		}},
+		{spec: "* * * *", wantErr: true},
+		{spec: "* * * * * *", wantErr: true},
+		{spec: "* 24 * * *", wantErr: true},
+		{spec: "* * * * 7", wantErr: true},
+	}
+
+	for _, test := range tests {
+		schedule, err := parseCron(test.spec)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseCron(%q) succeeded, want error", test.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCron(%q) error: %v", test.spec, err)
+			continue
+		}
+		for value, want := range test.times {
+			at, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := schedule.matches(at); got != want {
+				t.Errorf("parseCron(%q).matches(%s) = %v, want %v", test.spec, value, got, want)
+			}
+		}
+	}
+}
+
+func TestJobBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 0, want: SchedulerBaseBackoff},
+		{attempts: 1, want: SchedulerBaseBackoff},
+		{attempts: 2, want: 2 * SchedulerBaseBackoff},
+		{attempts: 3, want: 4 * SchedulerBaseBackoff},
+		{attempts: 7, want: 64 * SchedulerBaseBackoff},
+		{attempts: 8, want: SchedulerMaxBackoff},
+		{attempts: 100, want: SchedulerMaxBackoff},
+	}
+
+	for _, test := range tests {
+		if got := jobBackoff(test.attempts); got != test.want {
+			t.Errorf("jobBackoff(%d) = %v, want %v", test.attempts, got, test.want)
+		}
+	}
+}
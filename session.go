@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// BrowseSessionTTL is how long a partner browsing session stays resumable
+const BrowseSessionTTL = 12 * time.Hour
+
+// partnerCacheKeyPattern matches the keys of the old Redis partner list cache, browsing sessions replaced it
+const partnerCacheKeyPattern = "partners:*"
+
+// partnerCachePurgedKey marks the old partner list cache as purged, so the purge runs once
+const partnerCachePurgedKey = "migrations:partner_cache_purged"
+
+// the cleanup job removes expired browsing sessions every night
+func init() {
+	registerRecurringJob("browse_session_cleanup", "30 3 * * *", func(bot *tgbotapi.BotAPI) error {
+		return db.Where("expires_at < ?", time.Now()).Delete(&BrowseSession{}).Error
+	})
+}
+
+// BrowseSession stores a partner browsing session in the database, so browsing survives bot restarts
+type BrowseSession struct {
+	ID           uint      `gorm:"primary_key"`
+	SessionKey   string    `gorm:"unique_index;not null"` // random key used in callback data
+	UserID       int64     `gorm:"index"`                 // telegram ID of the browsing user
+	Language     string    // language filter snapshot
+	Exchange     bool      // language exchange filter snapshot
+	EnglishLevel string    // level range filter snapshot
+	Gender       string    // gender filter snapshot
+	PartnerIDs   string    // comma separated telegram IDs of matched partners, in display order
+	SeenIDs      string    // comma separated telegram IDs already shown in this session
+	Cursor       int       // index of the partner currently shown
+	ExpiresAt    time.Time // session can not be resumed after this time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// newSessionKey generates a short random session key
+func newSessionKey() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Println("Error generating session key:", err)
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// startBrowseSession creates a new browsing session for the user with a snapshot of the filters and partners
+func startBrowseSession(user *User, partners []*User) *BrowseSession {
+	partnerIDs := make([]int64, 0, len(partners))
+	for _, partner := range partners {
+		partnerIDs = append(partnerIDs, partner.TelegramID)
+	}
+
+	session := BrowseSession{
+		SessionKey:   newSessionKey(),
+		UserID:       user.TelegramID,
+		Language:     user.LastSelectedLanguage,
+		Exchange:     user.LastSelectedExchange,
+		EnglishLevel: user.LastSelectedEnglishLevel,
+		Gender:       user.LastSelectedGender,
+		PartnerIDs:   joinIDs(partnerIDs),
+		Cursor:       0,
+		ExpiresAt:    time.Now().Add(BrowseSessionTTL),
+	}
+	if err := db.Create(&session).Error; err != nil {
+		log.Println("Error creating browse session:", err)
+		return nil
+	}
+	return &session
+}
+
+// getActiveBrowseSession returns the latest not expired browsing session of the user, or nil
+func getActiveBrowseSession(telegramID int64) *BrowseSession {
+	var session BrowseSession
+	if err := db.Where("user_id = ? AND expires_at > ?", telegramID, time.Now()).Order("id desc").First(&session).Error; err != nil {
+		return nil
+	}
+	return &session
+}
+
+// getBrowseSessionByKey returns the not expired browsing session with the given key owned by the user, or nil
+func getBrowseSessionByKey(telegramID int64, sessionKey string) *BrowseSession {
+	var session BrowseSession
+	if err := db.Where("session_key = ? AND user_id = ? AND expires_at > ?", sessionKey, telegramID, time.Now()).First(&session).Error; err != nil {
+		return nil
+	}
+	return &session
+}
+
+// partners returns the telegram IDs of the session partners in display order
+func (s *BrowseSession) partners() []int64 {
+	return splitIDs(s.PartnerIDs)
+}
+
+// currentPartnerID returns the telegram ID of the partner under the cursor, or 0 if the cursor is out of range
+func (s *BrowseSession) currentPartnerID() int64 {
+	partnerIDs := s.partners()
+	if s.Cursor < 0 || s.Cursor >= len(partnerIDs) {
+		return 0
+	}
+	return partnerIDs[s.Cursor]
+}
+
+// hasPartner checks if the partner is part of this session
+func (s *BrowseSession) hasPartner(partnerID int64) bool {
+	return s.indexOf(partnerID) >= 0
+}
+
+// hasSeen checks if the partner was already shown in this session
+func (s *BrowseSession) hasSeen(partnerID int64) bool {
+	for _, id := range splitIDs(s.SeenIDs) {
+		if id == partnerID {
+			return true
+		}
+	}
+	return false
+}
+
+// markSeen adds the partner to the seen set of this session
+func (s *BrowseSession) markSeen(partnerID int64) {
+	if s.hasSeen(partnerID) {
+		return
+	}
+	s.SeenIDs = joinIDs(append(splitIDs(s.SeenIDs), partnerID))
+}
+
+// indexOf returns the position of the partner in the session, or -1 if the partner is not part of it
+func (s *BrowseSession) indexOf(partnerID int64) int {
+	for i, id := range s.partners() {
+		if id == partnerID {
+			return i
+		}
+	}
+	return -1
+}
+
+// setCursor moves the cursor to index and saves the session, returns false if there is no partner at index
+func (s *BrowseSession) setCursor(index int) bool {
+	if index < 0 || index >= len(s.partners()) {
+		return false
+	}
+	s.Cursor = index
+	db.Save(s)
+	return true
+}
+
+// joinIDs encodes telegram IDs as a comma separated string
+func joinIDs(ids []int64) string {
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		parts = append(parts, strconv.FormatInt(id, 10))
+	}
+	return strings.Join(parts, ",")
+}
+
+// splitIDs decodes a comma separated string of telegram IDs
+func splitIDs(value string) []int64 {
+	var ids []int64
+	for _, part := range strings.Split(value, ",") {
+		if part == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			log.Println("Error parsing telegram ID:", err)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// purgePartnerCache removes the keys of the old partner list cache once, they hold full copies of user profiles.
+// Browsing sessions store partner IDs only and cards are loaded from the database when shown.
+func purgePartnerCache(ctx context.Context) {
+	if purged, err := redisClient.Exists(ctx, partnerCachePurgedKey).Result(); err != nil || purged > 0 {
+		return
+	}
+
+	iter := redisClient.Scan(ctx, 0, partnerCacheKeyPattern, 100).Iterator()
+	for iter.Next(ctx) {
+		redisClient.Del(ctx, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		// the marker is not set, the purge runs again on the next start
+		log.Println("Error purging partner cache:", err)
+		return
+	}
+	redisClient.Set(ctx, partnerCachePurgedKey, time.Now().Unix(), 0)
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// bundledTopics is the topic bank shipped with the bot, TOPICS_FILE replaces it
+//
+//go:embed data/topics.json
+var bundledTopics []byte
+
+// topic bank settings
+const (
+	GeneralInterest   = "general" // interest of topics that suit everyone
+	MaxInterestLength = 32
+)
+
+// Topic is a conversation prompt for partners of a level
+type Topic struct {
+	ID        uint   `gorm:"primary_key"`
+	Level     string `gorm:"index"` // one of levelBands
+	Interest  string `gorm:"index"` // e.g. travel, GeneralInterest for everyone
+	Text      string `gorm:"unique;not null"`
+	CreatedAt time.Time
+}
+
+// TopicUsage stores the topics already served to a pair of partners, so topics are not repeated
+type TopicUsage struct {
+	ID        uint   `gorm:"primary_key"`
+	PairKey   string `gorm:"index"` // see topicPairKey
+	TopicID   uint
+	CreatedAt time.Time
+}
+
+// topicEntry is the format of a topic in the topic bank file
+type topicEntry struct {
+	Level    string `json:"level"`
+	Interest string `json:"interest"`
+	Text     string `json:"text"`
+}
+
+// seedTopics adds the topics of the topic bank from TOPICS_FILE, or the bundled one, that are not in the database yet
+func seedTopics() {
+	data := bundledTopics
+	if path := os.Getenv("TOPICS_FILE"); path != "" {
+		fileData, err := os.ReadFile(path)
+		if err != nil {
+			log.Println("Error reading topics file, using bundled topics:", err)
+		} else {
+			data = fileData
+		}
+	}
+
+	added, err := importTopics(data)
+	if err != nil {
+		log.Println("Error importing topics:", err)
+		return
+	}
+	if added > 0 {
+		log.Printf("Imported %d topics", added)
+	}
+}
+
+// importTopics adds the topics of a topic bank file that do not exist yet, returns the number of added topics
+func importTopics(data []byte) (int, error) {
+	var entries []topicEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return 0, err
+	}
+
+	added := 0
+	for _, entry := range entries {
+		topic, err := newTopic(entry.Level, entry.Interest, entry.Text)
+		if err != nil {
+			return added, err
+		}
+		var count int
+		db.Model(&Topic{}).Where("text = ?", topic.Text).Count(&count)
+		if count > 0 {
+			continue
+		}
+		if err := db.Create(&topic).Error; err != nil {
+			return added, err
+		}
+		added++
+	}
+	return added, nil
+}
+
+// newTopic validates and normalizes a topic
+func newTopic(level, interest, text string) (Topic, error) {
+	level, text = strings.TrimSpace(level), strings.TrimSpace(text)
+	interest = strings.ToLower(strings.TrimSpace(interest))
+	if _, _, ok := bandRange(level); !ok {
+		return Topic{}, fmt.Errorf("invalid level %q, use one of %s", level, strings.Join(levelBands, ", "))
+	}
+	if text == "" {
+		return Topic{}, fmt.Errorf("topic text is empty")
+	}
+	if interest == "" {
+		interest = GeneralInterest
+	}
+	// interests are sent in callback data, which is limited to 64 bytes
+	if len(interest) > MaxInterestLength || strings.Contains(interest, ",") {
+		return Topic{}, fmt.Errorf("invalid interest %q, use a short word", interest)
+	}
+	return Topic{Level: level, Interest: interest, Text: text}, nil
+}
+
+// pairTopicLevel returns the band of the lower English level of the two partners, so the topic suits both of them
+func pairTopicLevel(user, partner *User) string {
+	level := user.EnglishLevel
+	if partner.EnglishLevel.Valid() && (!level.Valid() || partner.EnglishLevel.Rank() < level.Rank()) {
+		level = partner.EnglishLevel
+	}
+	if !level.Valid() {
+		return levelBands[0]
+	}
+	return level.Band()
+}
+
+// topicPairKey identifies a pair of partners regardless of order
+func topicPairKey(userID, partnerID int64) string {
+	if userID > partnerID {
+		userID, partnerID = partnerID, userID
+	}
+	return fmt.Sprintf("%d:%d", userID, partnerID)
+}
+
+// topicInterests returns the interests of the topic bank, without the general interest
+func topicInterests() []string {
+	var interests []string
+	db.Model(&Topic{}).Where("interest != ?", GeneralInterest).Pluck("distinct interest", &interests)
+	sort.Strings(interests)
+	return interests
+}
+
+// pickTopic returns a topic of the pair level not served to the pair yet, preferring the interests of both users.
+// When every topic of the level was served, the history of the pair is cleared.
+func pickTopic(user, partner *User) (*Topic, error) {
+	level := pairTopicLevel(user, partner)
+	pairKey := topicPairKey(user.TelegramID, partner.TelegramID)
+	interests := append(append(splitInterests(user.Interests), splitInterests(partner.Interests)...), GeneralInterest)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		var usedIDs []uint
+		db.Model(&TopicUsage{}).Where("pair_key = ?", pairKey).Pluck("topic_id", &usedIDs)
+
+		// interests of the users first, then any interest of the level
+		for _, byInterest := range []bool{true, false} {
+			query := db.Where("level = ?", level)
+			if byInterest {
+				query = query.Where("interest IN (?)", interests)
+			}
+			if len(usedIDs) > 0 {
+				query = query.Not("id IN (?)", usedIDs)
+			}
+
+			var topic Topic
+			if err := query.Order("random()").First(&topic).Error; err == nil {
+				db.Create(&TopicUsage{PairKey: pairKey, TopicID: topic.ID})
+				return &topic, nil
+			}
+		}
+
+		// every topic of the level was served, start over
+		db.Where("pair_key = ?", pairKey).Delete(&TopicUsage{})
+	}
+	return nil, fmt.Errorf("no topics for level %s", level)
+}
+
+// splitInterests parses the comma separated interests of a user
+func splitInterests(interests string) []string {
+	var parsed []string
+	for _, interest := range strings.Split(interests, ",") {
+		if interest = strings.TrimSpace(interest); interest != "" {
+			parsed = append(parsed, interest)
+		}
+	}
+	return parsed
+}
+
+// handleTopicCommand asks which accepted partner the topic is for
+func handleTopicCommand(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, partnerID := range getConnectedPartnerIDs(user.TelegramID) {
+		var partner User
+		if err := db.Where("telegram_id = ?", partnerID).First(&partner).Error; err != nil {
+			continue
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(partner.Name, fmt.Sprintf("topic:pair:%d", partnerID)),
+		))
+	}
+
+	if len(rows) == 0 {
+		sendMessage(bot, chatID, "You have no accepted partners yet. Find a partner and send a follow request first.", mainKeyboard)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, "💡 Which partner do you need a topic for?")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	bot.Send(msg)
+}
+
+// interestsKeyboard builds the toggles of the interests of the topic bank
+func interestsKeyboard(user *User) tgbotapi.InlineKeyboardMarkup {
+	selected := map[string]bool{}
+	for _, interest := range splitInterests(user.Interests) {
+		selected[interest] = true
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+	for _, interest := range topicInterests() {
+		title := interest
+		if selected[interest] {
+			title = "✅ " + interest
+		}
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(title, "topic:interest:"+interest))
+		if len(row) == 3 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// handleEditInterests shows the interest toggles, topics of these interests are preferred
+func handleEditInterests(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	msg := tgbotapi.NewMessage(chatID, "🎯 Choose your interests, conversation topics about them are suggested first:")
+	msg.ReplyMarkup = interestsKeyboard(user)
+	bot.Send(msg)
+}
+
+// handleTopicCallback handles the partner choice, the another topic button and the interest toggles
+func handleTopicCallback(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	callback := update.CallbackQuery
+	chatID := callback.Message.Chat.ID
+	parts := strings.SplitN(strings.TrimPrefix(callback.Data, "topic:"), ":", 2)
+
+	var user User
+	if err := db.Where("telegram_id = ?", chatID).First(&user).Error; err != nil || len(parts) != 2 {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+
+	switch parts[0] {
+	case "pair":
+		partnerID, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || !isConnected(user.TelegramID, partnerID) {
+			log.Println("Invalid topic callback data:", callback.Data)
+			bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+			return
+		}
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		sendPairTopic(bot, &user, partnerID)
+	case "interest":
+		interest := parts[1]
+		var interests []string
+		found := false
+		for _, selected := range splitInterests(user.Interests) {
+			if selected == interest {
+				found = true
+				continue
+			}
+			interests = append(interests, selected)
+		}
+		if !found {
+			// only interests of the topic bank can be added, selected ones can always be removed
+			known := false
+			for _, topicInterest := range topicInterests() {
+				known = known || topicInterest == interest
+			}
+			if !known {
+				log.Println("Invalid topic callback data:", callback.Data)
+				bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+				return
+			}
+			interests = append(interests, interest)
+		}
+		user.Interests = strings.Join(interests, ",")
+		db.Model(&user).Update("interests", user.Interests)
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "Saved"))
+		bot.Send(tgbotapi.NewEditMessageReplyMarkup(chatID, callback.Message.MessageID, interestsKeyboard(&user)))
+	default:
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+	}
+}
+
+// sendPairTopic picks a new topic for the user and the partner and sends it to both of them
+func sendPairTopic(bot *tgbotapi.BotAPI, user *User, partnerID int64) {
+	var partner User
+	if err := db.Where("telegram_id = ?", partnerID).First(&partner).Error; err != nil {
+		sendErrorMessage(bot, user.TelegramID, "This partner is not available anymore.")
+		return
+	}
+
+	topic, err := pickTopic(user, &partner)
+	if err != nil {
+		log.Println("Error picking topic:", err)
+		sendErrorMessage(bot, user.TelegramID, "No topics are available for your level yet.")
+		return
+	}
+
+	for _, receiver := range []struct {
+		User    *User
+		Partner *User
+	}{{user, &partner}, {&partner, user}} {
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("🔄 Another topic", fmt.Sprintf("topic:pair:%d", receiver.Partner.TelegramID)),
+			),
+		)
+		sendMessage(bot, receiver.User.TelegramID, fmt.Sprintf("💡 Topic for you and %s (%s, %s):\n\n%s",
+			receiver.Partner.Name, topic.Level, topic.Interest, topic.Text), keyboard)
+	}
+	recordActivity(bot, user.TelegramID, ActivityTopic)
+}
+
+// handleAddTopicCommand adds a topic to the topic bank, only for admins.
+// Usage: /addtopic <level> | <interest> | <text>
+func handleAddTopicCommand(bot *tgbotapi.BotAPI, chatID int64, user *User, args string) {
+	if !isAdmin(user.TelegramID) {
+		return
+	}
+
+	parts := strings.SplitN(args, "|", 3)
+	if len(parts) != 3 {
+		sendMessage(bot, chatID, "Usage: /addtopic <level> | <interest> | <text>\nExample: /addtopic Beginner | food | What did you eat today?", mainKeyboard)
+		return
+	}
+
+	topic, err := newTopic(parts[0], parts[1], parts[2])
+	if err != nil {
+		sendMessage(bot, chatID, fmt.Sprintf("Invalid topic: %v", err), mainKeyboard)
+		return
+	}
+	if err := db.Create(&topic).Error; err != nil {
+		log.Println("Error creating topic:", err)
+		sendErrorMessage(bot, chatID, "Failed to add the topic, it may already exist.")
+		return
+	}
+	sendMessage(bot, chatID, fmt.Sprintf("Topic #%d added.", topic.ID), mainKeyboard)
+}
+
+// handleImportTopicsDocument imports a topic bank file sent with the /importtopics caption, only for admins.
+// The file has the format of data/topics.json.
+func handleImportTopicsDocument(bot *tgbotapi.BotAPI, message *tgbotapi.Message, user *User) {
+	if !isAdmin(user.TelegramID) {
+		return
+	}
+
+	fileURL, err := bot.GetFileDirectURL(message.Document.FileID)
+	if err != nil {
+		log.Println("Error getting topics file:", err)
+		sendErrorMessage(bot, message.Chat.ID, "Failed to download the file.")
+		return
+	}
+	response, err := http.Get(fileURL)
+	if err != nil {
+		log.Println("Error downloading topics file:", err)
+		sendErrorMessage(bot, message.Chat.ID, "Failed to download the file.")
+		return
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		log.Println("Error reading topics file:", err)
+		sendErrorMessage(bot, message.Chat.ID, "Failed to download the file.")
+		return
+	}
+
+	added, err := importTopics(data)
+	if err != nil {
+		sendMessage(bot, message.Chat.ID, fmt.Sprintf("Imported %d topics, then stopped: %v", added, err), mainKeyboard)
+		return
+	}
+	sendMessage(bot, message.Chat.ID, fmt.Sprintf("Imported %d new topics.", added), mainKeyboard)
+}
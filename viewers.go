@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// ViewersToShowLimit is the number of recent viewers listed in the who viewed me screen
+const ViewersToShowLimit = 10
+
+// ViewDigestHour is the local hour the daily profile view digest is sent at
+const ViewDigestHour = 20
+
+// profileViewer is a user who viewed the profile, with the time of the last view
+type profileViewer struct {
+	UserID   int64
+	ViewedAt time.Time
+}
+
+// getRecentViewers returns the users who viewed the profile since the given time, latest first.
+// Invisible views are not included.
+func getRecentViewers(telegramID int64, since time.Time, limit int) []profileViewer {
+	var viewers []profileViewer
+
+	rows, err := db.Model(&WatchList{}).
+		Select("user_id, max(created_at) as viewed_at").
+		Where("watch_id = ? AND invisible = ? AND created_at > ?", telegramID, false, since).
+		Group("user_id").Order("viewed_at desc").Limit(limit).Rows()
+	if err != nil {
+		log.Println("Error querying database for profile viewers:", err)
+		return viewers
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var viewer profileViewer
+		if err := rows.Scan(&viewer.UserID, &viewer.ViewedAt); err != nil {
+			log.Println("Error scanning profile viewer:", err)
+			continue
+		}
+		viewers = append(viewers, viewer)
+	}
+	return viewers
+}
+
+// countRecentViewers counts the distinct users who viewed the profile since the given time
+func countRecentViewers(telegramID int64, since time.Time) int {
+	var count int
+	db.Model(&WatchList{}).
+		Where("watch_id = ? AND invisible = ? AND created_at > ?", telegramID, false, since).
+		Select("count(distinct user_id)").Row().Scan(&count)
+	return count
+}
+
+// hasViewedProfile checks if the viewer has a visible view of the user's profile
+func hasViewedProfile(viewerID, telegramID int64) bool {
+	var count int
+	db.Model(&WatchList{}).Where("user_id = ? AND watch_id = ? AND invisible = ?", viewerID, telegramID, false).Count(&count)
+	return count > 0
+}
+
+// viewerSettingsKeyboard builds the digest and invisible browsing toggles
+func viewerSettingsKeyboard(user *User) tgbotapi.InlineKeyboardMarkup {
+	digest := "🔔 Daily digest: off"
+	if user.ViewDigest {
+		digest = "🔔 Daily digest: on"
+	}
+	invisible := "🕵️ Invisible browsing: off"
+	if user.BrowseInvisibly {
+		invisible = "🕵️ Invisible browsing: on"
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(digest, "viewers:digest")),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(invisible, "viewers:invisible")),
+	)
+}
+
+// handleWhoViewedMe shows the recent viewers of the user's profile, the list of viewers is a premium feature
+func handleWhoViewedMe(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	since := time.Now().AddDate(0, 0, -7)
+	count := countRecentViewers(user.TelegramID, since)
+
+	// settings are available for everyone
+	settings := tgbotapi.NewMessage(chatID, fmt.Sprintf("👀 %d people viewed your profile in the last 7 days.", count))
+	settings.ReplyMarkup = viewerSettingsKeyboard(user)
+	bot.Send(settings)
+
+	if count == 0 || !requirePremium(bot, chatID, user, "Seeing who viewed your profile") {
+		return
+	}
+
+	for _, viewer := range getRecentViewers(user.TelegramID, since, ViewersToShowLimit) {
+		var viewerUser User
+		if err := db.Where("telegram_id = ?", viewer.UserID).First(&viewerUser).Error; err != nil {
+			continue
+		}
+
+		text := fmt.Sprintf("👀 %s viewed your profile\nEnglish Level: %s\nViewed at: %s",
+			viewerUser.Name, viewerUser.EnglishLevel, viewer.ViewedAt.In(userLocation(user)).Format("2006-01-02 15:04"))
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("✅ Follow back", fmt.Sprintf("viewers:follow:%d", viewer.UserID)),
+			),
+		)
+		sendUserCard(bot, chatID, cardUserFor(&viewerUser, user), text, keyboard)
+	}
+}
+
+// handleViewersCallback handles the buttons of the who viewed me screen
+func handleViewersCallback(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	callback := update.CallbackQuery
+	chatID := callback.Message.Chat.ID
+
+	var user User
+	if err := db.Where("telegram_id = ?", chatID).First(&user).Error; err != nil {
+		log.Printf("Error getting user %d for viewers callback: %v", chatID, err)
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+
+	data := strings.TrimPrefix(callback.Data, "viewers:")
+	switch {
+	case data == "digest":
+		user.ViewDigest = !user.ViewDigest
+		db.Model(&user).Update("view_digest", user.ViewDigest)
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "Saved"))
+		bot.Send(tgbotapi.NewEditMessageReplyMarkup(chatID, callback.Message.MessageID, viewerSettingsKeyboard(&user)))
+	case data == "invisible":
+		user.BrowseInvisibly = !user.BrowseInvisibly
+		db.Model(&user).Update("browse_invisibly", user.BrowseInvisibly)
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "Saved"))
+		bot.Send(tgbotapi.NewEditMessageReplyMarkup(chatID, callback.Message.MessageID, viewerSettingsKeyboard(&user)))
+	case strings.HasPrefix(data, "follow:"):
+		viewerID, err := strconv.ParseInt(strings.TrimPrefix(data, "follow:"), 10, 64)
+		if err != nil || !hasViewedProfile(viewerID, user.TelegramID) {
+			log.Println("Invalid follow back callback data:", callback.Data)
+			bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+			return
+		}
+		handleFollowBack(bot, callback, &user, viewerID)
+	default:
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+	}
+}
+
+// handleFollowBack sends a follow request to a user who viewed the profile
+func handleFollowBack(bot *tgbotapi.BotAPI, callback *tgbotapi.CallbackQuery, user *User, viewerID int64) {
+	if blocked := followRequestBlockedText(user.TelegramID, viewerID); blocked != "" {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, blocked))
+		return
+	}
+	// check user limit for follow requests per day
+	if status, ok := consumeQuota(user, QuotaActionFollow); !ok {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallbackWithAlert(callback.ID, quotaExceededText(user, status)))
+		return
+	}
+	sendFollowRequest(bot, user, viewerID)
+	bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "Your follow request has been sent!"))
+}
+
+// the digest job checks every hour which users should get their daily profile view digest
+func init() {
+	registerRecurringJob("view_digest", "0 * * * *", sendViewDigests)
+}
+
+// sendViewDigests sends the daily digest to users who enabled it and whose local time is ViewDigestHour
+func sendViewDigests(bot *tgbotapi.BotAPI) error {
+	var users []User
+	if err := db.Where("view_digest = ?", true).Find(&users).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i := range users {
+		user := &users[i]
+		local := now.In(userLocation(user))
+		if local.Hour() != ViewDigestHour {
+			continue
+		}
+		dayStart := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+		if !user.LastViewDigestAt.Before(dayStart) {
+			continue
+		}
+
+		db.Model(user).Update("last_view_digest_at", now)
+		if count := countRecentViewers(user.TelegramID, dayStart); count > 0 {
+			sendMessage(bot, user.TelegramID, fmt.Sprintf("👀 %d people viewed your profile today.", count), mainKeyboard)
+		}
+	}
+	return nil
+}
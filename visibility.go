@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/jinzhu/gorm"
+)
+
+// pauseDays lists the pause durations offered in the visibility settings
+var pauseDays = []int{1, 3, 7, 30}
+
+// isVerified checks if the user is verified, verified users took the placement test
+func isVerified(user *User) bool {
+	return user.PlacementTestedAt != nil
+}
+
+// isPaused checks if the user paused being shown to partners
+func isPaused(user *User) bool {
+	return user.PausedUntil != nil && user.PausedUntil.After(time.Now())
+}
+
+// visibleToQuery limits a users query to the users the viewer may discover: visible in search, not paused,
+// not deleting their account, and not limited to verified users unless the viewer is verified
+func visibleToQuery(query *gorm.DB, viewer *User) *gorm.DB {
+	query = query.Where("hidden_from_search = ?", false).Where("paused_until IS NULL OR paused_until < ?", time.Now()).
+		Where("deletion_scheduled_at IS NULL")
+	if !isVerified(viewer) {
+		query = query.Where("verified_only = ?", false)
+	}
+	return query
+}
+
+// visibilityBlockedText returns why the viewer can not see the profile of the owner outside of search, or "" if allowed.
+// Users hidden from search are still shown to viewers with their profile link.
+func visibilityBlockedText(viewer, owner *User) string {
+	if owner.DeletionScheduledAt != nil {
+		return "This profile is not available."
+	}
+	if isPaused(owner) {
+		return "This user is taking a break, please try again later."
+	}
+	if owner.VerifiedOnly && !isVerified(viewer) {
+		return "This user is only shown to verified users. Take the 📝 Placement test to get verified."
+	}
+	return ""
+}
+
+// livePracticeBlockedText returns why the user can not join practice now or group practice, or "" if allowed.
+// Users deleting their account or paused are hidden from everyone, so they are not paired either.
+func livePracticeBlockedText(user *User) string {
+	if user.DeletionScheduledAt != nil {
+		return "Your account is being deleted. Use /deleteaccount to keep it and practice again."
+	}
+	if isPaused(user) {
+		return "You paused your profile. Resume it in ⚙️ Visibility to practice with others."
+	}
+	return ""
+}
+
+// photoVisibleTo checks if the viewer can see the profile photo of the owner,
+// a hidden photo is shown to connected partners only
+func photoVisibleTo(owner, viewer *User) bool {
+	return !owner.HidePhoto || isConnected(owner.TelegramID, viewer.TelegramID)
+}
+
+// cardUserFor returns the owner as shown on a card to the viewer, without the photo if it is hidden from the viewer
+func cardUserFor(owner, viewer *User) *User {
+	if photoVisibleTo(owner, viewer) {
+		return owner
+	}
+	shown := *owner
+	shown.MediaID = 0
+	return &shown
+}
+
+// visibilitySettingsText describes the visibility settings of the user
+func visibilitySettingsText(user *User) string {
+	status := "✅ You are shown in Find Partner."
+	if user.HiddenFromSearch {
+		status = "🙈 You are hidden from Find Partner, only people with your profile link can find you."
+	}
+	if isPaused(user) {
+		status = fmt.Sprintf("⏸️ You are paused until %s and not shown to anyone.",
+			user.PausedUntil.In(userLocation(user)).Format("2006-01-02 15:04"))
+	}
+	return "⚙️ Visibility settings\n\n" + status + "\n\nVerified users are users with the ✔️ tested badge of the 📝 Placement test."
+}
+
+// visibilityKeyboard builds the toggles of the visibility settings
+func visibilityKeyboard(user *User) tgbotapi.InlineKeyboardMarkup {
+	toggle := func(title string, on bool, data string) []tgbotapi.InlineKeyboardButton {
+		state := "off"
+		if on {
+			state = "on"
+		}
+		return tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%s: %s", title, state), "vis:"+data))
+	}
+
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		toggle("👀 Visible in search", !user.HiddenFromSearch, "visible"),
+		toggle("✔️ Only show me to verified users", user.VerifiedOnly, "verified"),
+		toggle("🖼️ Hide my photo until I accept", user.HidePhoto, "photo"),
+		toggle("🕵️ Incognito browsing", user.BrowseInvisibly, "incognito"),
+	}
+	if isPaused(user) {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("▶️ Resume now", "vis:resume")))
+	} else {
+		var row []tgbotapi.InlineKeyboardButton
+		for _, days := range pauseDays {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("⏸️ %dd", days), fmt.Sprintf("vis:pause:%d", days)))
+		}
+		rows = append(rows, row)
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// handleVisibilitySettings shows the visibility settings screen
+func handleVisibilitySettings(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	sendMessage(bot, chatID, visibilitySettingsText(user), visibilityKeyboard(user))
+}
+
+// handleVisibilityCallback handles the toggles of the visibility settings
+func handleVisibilityCallback(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	callback := update.CallbackQuery
+	chatID := callback.Message.Chat.ID
+
+	var user User
+	if err := db.Where("telegram_id = ?", chatID).First(&user).Error; err != nil {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+
+	data := strings.TrimPrefix(callback.Data, "vis:")
+	switch {
+	case data == "visible":
+		user.HiddenFromSearch = !user.HiddenFromSearch
+		db.Model(&user).Update("hidden_from_search", user.HiddenFromSearch)
+	case data == "verified":
+		user.VerifiedOnly = !user.VerifiedOnly
+		db.Model(&user).Update("verified_only", user.VerifiedOnly)
+	case data == "photo":
+		user.HidePhoto = !user.HidePhoto
+		db.Model(&user).Update("hide_photo", user.HidePhoto)
+	case data == "incognito":
+		user.BrowseInvisibly = !user.BrowseInvisibly
+		db.Model(&user).Update("browse_invisibly", user.BrowseInvisibly)
+	case data == "resume":
+		user.PausedUntil = nil
+		db.Model(&user).Update("paused_until", gorm.Expr("NULL"))
+	case strings.HasPrefix(data, "pause:"):
+		days, err := strconv.Atoi(strings.TrimPrefix(data, "pause:"))
+		if err != nil || days <= 0 || days > pauseDays[len(pauseDays)-1] {
+			bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+			return
+		}
+		until := time.Now().AddDate(0, 0, days)
+		user.PausedUntil = &until
+		db.Model(&user).Update("paused_until", &until)
+	default:
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+
+	bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "Saved"))
+	edit := tgbotapi.NewEditMessageText(chatID, callback.Message.MessageID, visibilitySettingsText(&user))
+	keyboard := visibilityKeyboard(&user)
+	edit.ReplyMarkup = &keyboard
+	bot.Send(edit)
+}
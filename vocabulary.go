@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"html"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// vocabulary settings
+const (
+	MaxVocabWords         = 2000 // words a user can save
+	MaxVocabWordLength    = 100
+	MaxVocabMeaningLength = 300
+	VocabReviewHour       = 9 // local hour the daily review reminder is sent
+	vocabInitialEase      = 2.5
+	vocabMinEase          = 1.3
+)
+
+// review grades of the SM-2 algorithm, from 0 (blackout) to 5 (perfect)
+const (
+	VocabGradeAgain = 1
+	VocabGradeHard  = 3
+	VocabGradeGood  = 4
+	VocabGradeEasy  = 5
+)
+
+// VocabWord is a word or phrase saved by a user, reviewed with the SM-2 spaced repetition algorithm
+type VocabWord struct {
+	ID          uint   `gorm:"primary_key"`
+	UserID      int64  `gorm:"unique_index:idx_vocab_user_word"` // telegram ID of the user
+	WordKey     string `gorm:"unique_index:idx_vocab_user_word"` // lower case word, so a word is saved once
+	Word        string
+	Meaning     string
+	Example     string
+	Ease        float64   // SM-2 easiness factor
+	Interval    int       // days until the next review
+	Repetitions int       // successful reviews in a row
+	DueAt       time.Time `gorm:"index"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// the review job checks every hour which users should get their daily review reminder
+func init() {
+	registerRecurringJob("vocab_review", "0 * * * *", sendVocabReviewReminders)
+}
+
+// handleWordCommand saves a word to the notebook of the user.
+// Usage: /word <word> | <meaning> | <example>, the example is optional
+func handleWordCommand(bot *tgbotapi.BotAPI, chatID int64, user *User, args string) {
+	parts := strings.SplitN(args, "|", 3)
+	if len(parts) < 2 {
+		var count, due int
+		db.Model(&VocabWord{}).Where("user_id = ?", user.TelegramID).Count(&count)
+		db.Model(&VocabWord{}).Where("user_id = ? AND due_at <= ?", user.TelegramID, time.Now()).Count(&due)
+		sendMessage(bot, chatID, fmt.Sprintf("📚 Your notebook has %d words, %d to review.\n\n"+
+			"Save a word: /word <word> | <meaning> | <example>\nExample: /word break the ice | start a conversation | He told a joke to break the ice.\n\n"+
+			"Review now: /review\nExport: /exportwords (CSV) or /exportwords anki (Anki TSV)", count, due), mainKeyboard)
+		return
+	}
+
+	word := strings.Join(strings.Fields(parts[0]), " ")
+	meaning := strings.TrimSpace(parts[1])
+	example := ""
+	if len(parts) == 3 {
+		example = strings.TrimSpace(parts[2])
+	}
+	if word == "" || meaning == "" {
+		sendErrorMessage(bot, chatID, "Please send both the word and its meaning.")
+		return
+	}
+	if utf8.RuneCountInString(word) > MaxVocabWordLength || utf8.RuneCountInString(meaning) > MaxVocabMeaningLength ||
+		utf8.RuneCountInString(example) > MaxVocabMeaningLength {
+		sendErrorMessage(bot, chatID, fmt.Sprintf("The word can have up to %d characters, the meaning and the example up to %d.",
+			MaxVocabWordLength, MaxVocabMeaningLength))
+		return
+	}
+
+	vocab := VocabWord{UserID: user.TelegramID, WordKey: strings.ToLower(word)}
+	if db.Where(vocab).First(&vocab).RecordNotFound() {
+		var count int
+		db.Model(&VocabWord{}).Where("user_id = ?", user.TelegramID).Count(&count)
+		if count >= MaxVocabWords {
+			sendErrorMessage(bot, chatID, fmt.Sprintf("Your notebook is full (%d words).", MaxVocabWords))
+			return
+		}
+		// new words are due tomorrow, learning starts with the first review
+		vocab.Ease = vocabInitialEase
+		vocab.DueAt = time.Now().Add(24 * time.Hour)
+	}
+	vocab.Word, vocab.Meaning, vocab.Example = word, meaning, example
+	if err := db.Save(&vocab).Error; err != nil {
+		log.Println("Error saving vocabulary word:", err)
+		sendErrorMessage(bot, chatID, "Failed to save the word. Please try again.")
+		return
+	}
+
+	sendMessage(bot, chatID, fmt.Sprintf("📚 Saved \"%s\". It will come up in your daily review.", vocab.Word), mainKeyboard)
+}
+
+// handleReviewCommand starts a review of the due words
+func handleReviewCommand(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	word := nextDueWord(user.TelegramID)
+	if word == nil {
+		sendMessage(bot, chatID, "✅ No words to review right now. Save new words with /word.", mainKeyboard)
+		return
+	}
+	sendMessage(bot, chatID, vocabQuestionText(word), vocabQuestionKeyboard(word))
+}
+
+// nextDueWord returns the word of the user waiting longest for its review, or nil if no word is due
+func nextDueWord(telegramID int64) *VocabWord {
+	var word VocabWord
+	if err := db.Where("user_id = ? AND due_at <= ?", telegramID, time.Now()).Order("due_at").First(&word).Error; err != nil {
+		return nil
+	}
+	return &word
+}
+
+// vocabQuestionText shows the front of the card
+func vocabQuestionText(word *VocabWord) string {
+	return fmt.Sprintf("📚 Do you remember the meaning?\n\n%s", word.Word)
+}
+
+// vocabQuestionKeyboard reveals the answer of the card
+func vocabQuestionKeyboard(word *VocabWord) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("👀 Show answer", fmt.Sprintf("vocab:answer:%d", word.ID)),
+	))
+}
+
+// vocabAnswerKeyboard grades the answer of the card
+func vocabAnswerKeyboard(word *VocabWord) tgbotapi.InlineKeyboardMarkup {
+	grade := func(title string, grade int) tgbotapi.InlineKeyboardButton {
+		return tgbotapi.NewInlineKeyboardButtonData(title, fmt.Sprintf("vocab:grade:%d:%d", word.ID, grade))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		grade("❌ Again", VocabGradeAgain),
+		grade("😐 Hard", VocabGradeHard),
+		grade("🙂 Good", VocabGradeGood),
+		grade("😎 Easy", VocabGradeEasy),
+	))
+}
+
+// handleVocabCallback handles the review buttons, the review message is edited from card to card
+func handleVocabCallback(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	callback := update.CallbackQuery
+	chatID := callback.Message.Chat.ID
+	parts := strings.Split(strings.TrimPrefix(callback.Data, "vocab:"), ":")
+
+	if parts[0] == "start" {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		removeInlineKeyboard(bot, callback.Message)
+		handleReviewCommand(bot, chatID, &User{TelegramID: chatID})
+		return
+	}
+
+	var word VocabWord
+	if len(parts) < 2 || db.Where("id = ? AND user_id = ?", parts[1], chatID).First(&word).Error != nil {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "This word is not in your notebook anymore."))
+		removeInlineKeyboard(bot, callback.Message)
+		return
+	}
+
+	var text string
+	var keyboard tgbotapi.InlineKeyboardMarkup
+	switch {
+	case parts[0] == "answer":
+		text = fmt.Sprintf("📚 %s\n\n💬 %s", word.Word, word.Meaning)
+		if word.Example != "" {
+			text += "\n📝 " + word.Example
+		}
+		keyboard = vocabAnswerKeyboard(&word)
+	case parts[0] == "grade" && len(parts) == 3:
+		grade, err := strconv.Atoi(parts[2])
+		if err != nil || grade < 0 || grade > 5 || word.DueAt.After(time.Now()) {
+			// a card graded twice from an old message keeps its schedule
+			bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+			removeInlineKeyboard(bot, callback.Message)
+			return
+		}
+		word.review(grade, time.Now())
+		db.Save(&word)
+
+		next := nextDueWord(chatID)
+		if next == nil {
+			bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+			bot.Send(tgbotapi.NewEditMessageText(chatID, callback.Message.MessageID, "✅ Review done, see you tomorrow!"))
+			return
+		}
+		text, keyboard = vocabQuestionText(next), vocabQuestionKeyboard(next)
+	default:
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+
+	bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, ""))
+	edit := tgbotapi.NewEditMessageText(chatID, callback.Message.MessageID, text)
+	edit.ReplyMarkup = &keyboard
+	bot.Send(edit)
+}
+
+// review schedules the next review of the word with the SM-2 algorithm
+func (w *VocabWord) review(grade int, now time.Time) {
+	if grade < VocabGradeHard {
+		// forgotten words start over, their ease still drops
+		w.Repetitions = 0
+		w.Interval = 1
+	} else {
+		w.Repetitions++
+		switch w.Repetitions {
+		case 1:
+			w.Interval = 1
+		case 2:
+			w.Interval = 6
+		default:
+			w.Interval = int(math.Round(float64(w.Interval) * w.Ease))
+		}
+	}
+
+	quality := float64(5 - grade)
+	w.Ease = math.Max(vocabMinEase, w.Ease+0.1-quality*(0.08+quality*0.02))
+	w.DueAt = now.AddDate(0, 0, w.Interval)
+}
+
+// sendVocabReviewReminders reminds users with due words at VocabReviewHour of their local time, once a day
+func sendVocabReviewReminders(bot *tgbotapi.BotAPI) error {
+	var userIDs []int64
+	if err := db.Model(&VocabWord{}).Where("due_at <= ?", time.Now()).Pluck("distinct user_id", &userIDs).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, userID := range userIDs {
+		var user User
+		if err := db.Where("telegram_id = ?", userID).First(&user).Error; err != nil {
+			continue
+		}
+		local := now.In(userLocation(&user))
+		dayStart := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+		if local.Hour() != VocabReviewHour || !user.LastVocabReviewAt.Before(dayStart) {
+			continue
+		}
+
+		db.Model(&user).Update("last_vocab_review_at", now)
+		var due int
+		db.Model(&VocabWord{}).Where("user_id = ? AND due_at <= ?", userID, now).Count(&due)
+		sendMessage(bot, userID, fmt.Sprintf("📚 %d words are waiting for your daily review.", due),
+			tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("▶️ Start review", "vocab:start"),
+			)))
+	}
+	return nil
+}
+
+// handleExportWordsCommand sends the notebook as a CSV file, or as a TSV file Anki can import with "anki"
+func handleExportWordsCommand(bot *tgbotapi.BotAPI, chatID int64, user *User, args string) {
+	var words []VocabWord
+	db.Where("user_id = ?", user.TelegramID).Order("created_at").Find(&words)
+	if len(words) == 0 {
+		sendMessage(bot, chatID, "Your notebook is empty. Save words with /word.", mainKeyboard)
+		return
+	}
+
+	var file tgbotapi.FileBytes
+	if strings.EqualFold(strings.TrimSpace(args), "anki") {
+		file = tgbotapi.FileBytes{Name: "vocabulary-anki.txt", Bytes: vocabAnkiTSV(words)}
+	} else {
+		data, err := vocabCSV(words)
+		if err != nil {
+			log.Println("Error exporting vocabulary:", err)
+			sendErrorMessage(bot, chatID, "Failed to export your notebook. Please try again.")
+			return
+		}
+		file = tgbotapi.FileBytes{Name: "vocabulary.csv", Bytes: data}
+	}
+
+	document := tgbotapi.NewDocumentUpload(chatID, file)
+	document.Caption = fmt.Sprintf("📚 %d words", len(words))
+	if _, err := bot.Send(document); err != nil {
+		log.Println("Error sending vocabulary export:", err)
+	}
+}
+
+// vocabCSV exports the words with their review state
+func vocabCSV(words []VocabWord) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write([]string{"word", "meaning", "example", "added", "next_review"})
+	for _, word := range words {
+		writer.Write([]string{word.Word, word.Meaning, word.Example, word.CreatedAt.Format("2006-01-02"), word.DueAt.Format("2006-01-02")})
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+// vocabAnkiTSV exports the words as Anki notes: the word on the front, the meaning and the example on the back
+func vocabAnkiTSV(words []VocabWord) []byte {
+	replacer := strings.NewReplacer("\t", " ", "\r", "", "\n", "<br>")
+	clean := func(text string) string {
+		return replacer.Replace(html.EscapeString(text))
+	}
+	lines := []string{"#separator:tab", "#html:true"}
+	for _, word := range words {
+		back := clean(word.Meaning)
+		if word.Example != "" {
+			back += "<br><i>" + clean(word.Example) + "</i>"
+		}
+		lines = append(lines, clean(word.Word)+"\t"+back)
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}